@@ -2,28 +2,57 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog"
 	"k8s.io/kubernetes/pkg/genericcontrolplane/clientutils"
 
 	"github.com/kcp-dev/kcp/pkg/reconciler/apiresource"
 	"github.com/kcp-dev/kcp/pkg/reconciler/cluster"
+	"github.com/kcp-dev/kcp/pkg/syncer"
 )
 
 const numThreads = 2
 
 var (
-	kubeconfigPath  = flag.String("kubeconfig", "", "Path to kubeconfig")
-	syncerImage     = flag.String("syncer_image", "", "Syncer image to install on clusters")
-	pullMode        = flag.Bool("pull_mode", true, "Deploy the syncer in registered physical clusters in POD, and have it sync resources from KCP")
-	pushMode        = flag.Bool("push_mode", false, "If true, run syncer for each cluster from inside cluster controller")
-	autoPublishAPIs = flag.Bool("auto_publish_apis", false, "If true, the APIs imported from physical clusters will be published automatically as CRDs")
+	kubeconfigPath   = flag.String("kubeconfig", "", "Path to kubeconfig")
+	syncerImage      = flag.String("syncer_image", "", "Syncer image to install on clusters")
+	pullMode         = flag.Bool("pull_mode", true, "Deploy the syncer in registered physical clusters in POD, and have it sync resources from KCP")
+	pushMode         = flag.Bool("push_mode", false, "If true, run syncer for each cluster from inside cluster controller")
+	autoPublishAPIs  = flag.Bool("auto_publish_apis", false, "If true, the APIs imported from physical clusters will be published automatically as CRDs")
+	serverSideApply  = flag.Bool("server_side_apply", false, "If true, syncers sync objects to physical clusters with server-side apply instead of full updates")
+	clusterScoped    = flag.String("cluster_scoped_resources", "", "Comma-separated list of cluster-scoped resource types which syncers are allowed to sync")
+	nsMapping        = flag.String("namespace_mapping", syncer.NamespaceMappingIdentity, "How namespaces of logical clusters are mapped to namespaces of physical clusters: 'identity' or 'prefixed'")
+	transformsFile   = flag.String("transformations", "", "YAML file with the transformations syncers apply to the objects synced to physical clusters")
+	driftPolicy      = flag.String("drift_policy", syncer.DriftPolicyRevert, "What syncers do when objects synced to physical clusters are changed there: 'revert' or 'report'")
+	orphanPolicy     = flag.String("orphan_policy", syncer.OrphanPolicyDelete, "What syncers do on startup with objects synced to physical clusters whose object in kcp is gone: 'delete' or 'report'")
+	adoptPolicy      = flag.String("adopt_policy", syncer.AdoptPolicyNever, "What syncers do with objects to sync which already exist in physical clusters and were not created by kcp: 'never' overwrite them, or 'always' take them over")
+	workers          = flag.String("resource_workers", "", "Comma-separated list of resource=workers pairs, e.g. deployments.apps=4, overriding the number of workers syncers use for a resource type")
+	syncDependencies = flag.Bool("sync_dependencies", false, "If true, syncers also sync the ConfigMaps, Secrets, ServiceAccounts and PersistentVolumeClaims referenced by synced workloads")
+	syncEvents       = flag.Bool("sync_events", false, "If true, syncers republish the Events of physical clusters about synced objects in kcp")
+	metricsAddress   = flag.String("metrics_address", ":8081", "Address to serve metrics, including the ones of the syncers in push mode, on /metrics, or empty to not serve metrics")
+	syncerReplicas   = flag.Int("syncer_replicas", 1, "Number of syncer replicas deployed on each cluster in pull mode, one of which is elected leader and syncs")
 )
 
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	klog.Fatal(http.ListenAndServe(address, mux))
+}
+
 func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -61,12 +90,54 @@ func main() {
 		syncerMode = cluster.SyncerModePush
 	}
 
-	clusterController, err := cluster.NewController(r, *syncerImage, kubeconfig, resourcesToSync, syncerMode)
+	if _, err := syncer.NewNamespaceMapper(*nsMapping); err != nil {
+		klog.Fatal(err)
+	}
+	if *driftPolicy != syncer.DriftPolicyRevert && *driftPolicy != syncer.DriftPolicyReport {
+		klog.Fatalf("unknown drift policy %q", *driftPolicy)
+	}
+	if *orphanPolicy != syncer.OrphanPolicyDelete && *orphanPolicy != syncer.OrphanPolicyReport {
+		klog.Fatalf("unknown orphan policy %q", *orphanPolicy)
+	}
+	if *adoptPolicy != syncer.AdoptPolicyNever && *adoptPolicy != syncer.AdoptPolicyAlways {
+		klog.Fatalf("unknown adopt policy %q", *adoptPolicy)
+	}
+
+	resourceWorkers, err := syncer.ParseResourceWorkers(*workers)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	var transformations []syncer.TransformationSpec
+	if *transformsFile != "" {
+		transformations, err = syncer.LoadTransformations(*transformsFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	clusterController, err := cluster.NewController(r, *syncerImage, kubeconfig, resourcesToSync, syncerMode, syncer.Options{
+		ServerSideApply:        *serverSideApply,
+		NamespaceMapping:       *nsMapping,
+		ClusterScopedResources: splitList(*clusterScoped),
+		Transformations:        transformations,
+		DriftPolicy:            *driftPolicy,
+		OrphanPolicy:           *orphanPolicy,
+		AdoptPolicy:            *adoptPolicy,
+		ResourceWorkers:        resourceWorkers,
+		SyncDependencies:       *syncDependencies,
+		SyncEvents:             *syncEvents,
+	}, int32(*syncerReplicas))
 	if err != nil {
 		klog.Fatal(err)
 	}
 	clusterController.Start(numThreads)
 
+	if *metricsAddress != "" {
+		syncer.RegisterMetrics()
+		go serveMetrics(*metricsAddress)
+	}
+
 	apiresourceController, err := apiresource.NewController(
 		r,
 		*autoPublishAPIs,
@@ -1,11 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog"
 
 	"github.com/kcp-dev/kcp/pkg/syncer"
@@ -14,13 +29,107 @@ import (
 const numThreads = 2
 
 var (
-	fromKubeconfig = flag.String("from_kubeconfig", "", "Kubeconfig file for -from cluster")
-	fromContext    = flag.String("from_context", "", "Context to use in the Kubeconfig file for -from cluster, instead of the current context")
-	toKubeconfig   = flag.String("to_kubeconfig", "", "Kubeconfig file for -to cluster. If not set, the InCluster configuration will be used")
-	toContext      = flag.String("to_context", "", "Context to use in the Kubeconfig file for -to cluster, instead of the current context")
-	clusterID      = flag.String("cluster", "", "ID of this cluster")
+	fromKubeconfig   = flag.String("from_kubeconfig", "", "Kubeconfig file for -from cluster")
+	fromContext      = flag.String("from_context", "", "Context to use in the Kubeconfig file for -from cluster, instead of the current context")
+	toKubeconfig     = flag.String("to_kubeconfig", "", "Kubeconfig file for -to cluster. If not set, the InCluster configuration will be used")
+	toContext        = flag.String("to_context", "", "Context to use in the Kubeconfig file for -to cluster, instead of the current context")
+	clusterID        = flag.String("cluster", "", "ID of this cluster")
+	logicalCluster   = flag.String("logical_cluster", "", "Logical cluster of the -from cluster synced to this cluster. Only its orphaned objects are cleaned up on startup")
+	apply            = flag.Bool("server_side_apply", false, "If true, sync objects to the -to cluster with server-side apply instead of full updates")
+	clusterScoped    = flag.String("cluster_scoped_resources", "", "Comma-separated list of cluster-scoped resource types which are allowed to be synced")
+	nsMapping        = flag.String("namespace_mapping", syncer.NamespaceMappingIdentity, "How namespaces of the -from cluster are mapped to namespaces of the -to cluster: 'identity' or 'prefixed'")
+	transformsFile   = flag.String("transformations", "", "YAML file with the transformations applied to the objects synced to the -to cluster")
+	driftPolicy      = flag.String("drift_policy", syncer.DriftPolicyRevert, "What to do when objects synced to the -to cluster are changed there: 'revert' or 'report'")
+	orphanPolicy     = flag.String("orphan_policy", syncer.OrphanPolicyDelete, "What to do on startup with objects synced to the -to cluster whose object in the -from cluster is gone: 'delete' or 'report'")
+	adoptPolicy      = flag.String("adopt_policy", syncer.AdoptPolicyNever, "What to do with objects to sync which already exist in the -to cluster and were not created by kcp: 'never' overwrite them, or 'always' take them over")
+	workers          = flag.String("resource_workers", "", "Comma-separated list of resource=workers pairs, e.g. deployments.apps=4, overriding the number of workers syncing a resource type")
+	syncDependencies = flag.Bool("sync_dependencies", false, "If true, also sync the ConfigMaps, Secrets, ServiceAccounts and PersistentVolumeClaims referenced by synced workloads")
+	syncEvents       = flag.Bool("sync_events", false, "If true, republish the Events of the -to cluster about synced objects in the -from cluster")
+	dryRun           = flag.Bool("dry_run", false, "If true, don't change anything, but report the changes the syncer would make")
+	dryRunOutput     = flag.String("dry_run_output", "", "File the changes are written to as JSON lines in dry-run mode. They are logged if empty")
+	httpAddress      = flag.String("http_address", ":8080", "Address to serve /metrics, /healthz and /readyz on, or empty to not serve them")
+	leaderElect      = flag.Bool("leader_elect", false, "If true, elect a leader among the syncer replicas with a Lease in the -to cluster, so that only the leader syncs")
+	leaseNamespace   = flag.String("leader_election_namespace", "", "Namespace of the leader election Lease. Defaults to the namespace of the syncer")
+	leaseName        = flag.String("leader_election_id", "", "Name of the leader election Lease. Defaults to kcp-syncer-<cluster>")
+	once             = flag.Bool("once", false, "If true, sync all the objects once, wait for their statuses, print a summary and exit, with a non-zero code on any failure")
+	onceTimeout      = flag.Duration("once_timeout", 5*time.Minute, "How long -once waits for the objects to be synced and their statuses to be copied back")
 )
 
+// runningSyncer holds the syncer once it is started, for the health checks.
+type runningSyncer struct {
+	lock   sync.RWMutex
+	syncer *syncer.Syncer
+	// standby replicas, waiting to be elected leader, are healthy and ready.
+	standby bool
+}
+
+func (r *runningSyncer) set(s *syncer.Syncer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.syncer = s
+}
+
+// check runs the given checks of the syncer, once it is started.
+func (r *runningSyncer) check(checks func(*syncer.Syncer) []healthz.HealthChecker) func(*http.Request) error {
+	return func(req *http.Request) error {
+		r.lock.RLock()
+		s := r.syncer
+		r.lock.RUnlock()
+
+		if s == nil {
+			if r.standby {
+				return nil
+			}
+			return fmt.Errorf("syncer not started yet")
+		}
+		for _, check := range checks(s) {
+			if err := check.Check(req); err != nil {
+				return fmt.Errorf("%s: %w", check.Name(), err)
+			}
+		}
+		return nil
+	}
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func serve(address string, r *runningSyncer) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	healthz.InstallHandler(mux, healthz.NamedCheck("syncer", r.check((*syncer.Syncer).HealthChecks)))
+	healthz.InstallReadyzHandler(mux, healthz.NamedCheck("syncer", r.check((*syncer.Syncer).ReadyChecks)))
+	klog.Fatal(http.ListenAndServe(address, mux))
+}
+
+// runOnce syncs all the objects once, prints a summary and returns the exit code.
+func runOnce(ctx context.Context, fromConfig, toConfig *rest.Config, syncedResourceTypes []string, opts syncer.Options, running *runningSyncer) int {
+	opts.Once = true
+	if f, isFile := opts.DryRunOutput.(*os.File); isFile {
+		defer f.Close()
+	}
+	s, err := syncer.StartSyncer(fromConfig, toConfig, sets.NewString(syncedResourceTypes...), *clusterID, numThreads, opts)
+	if err != nil {
+		klog.Error(err)
+		return 1
+	}
+	running.set(s)
+	defer s.Stop()
+
+	ctx, cancel := context.WithTimeout(ctx, *onceTimeout)
+	defer cancel()
+	summary := s.WaitUntilSynced(ctx)
+	fmt.Print(summary)
+	if summary.Failed() {
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	flag.Parse()
 	syncedResourceTypes := flag.Args()
@@ -62,12 +171,118 @@ func main() {
 		klog.Fatal(err)
 	}
 
-	syncer, err := syncer.StartSyncer(fromConfig, toConfig, sets.NewString(syncedResourceTypes...), *clusterID, numThreads)
+	var transformations []syncer.TransformationSpec
+	if *transformsFile != "" {
+		transformations, err = syncer.LoadTransformations(*transformsFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	if *once && *leaderElect {
+		klog.Fatal("-once can't be combined with -leader_elect")
+	}
+
+	resourceWorkers, err := syncer.ParseResourceWorkers(*workers)
 	if err != nil {
 		klog.Fatal(err)
 	}
-	klog.Infoln("Starting workers")
 
-	syncer.WaitUntilDone()
-	klog.Infoln("Stopping workers")
+	opts := syncer.Options{
+		ServerSideApply:        *apply,
+		NamespaceMapping:       *nsMapping,
+		ClusterScopedResources: splitList(*clusterScoped),
+		Transformations:        transformations,
+		DriftPolicy:            *driftPolicy,
+		OrphanPolicy:           *orphanPolicy,
+		LogicalCluster:         *logicalCluster,
+		AdoptPolicy:            *adoptPolicy,
+		ResourceWorkers:        resourceWorkers,
+		SyncDependencies:       *syncDependencies,
+		SyncEvents:             *syncEvents,
+		DryRun:                 *dryRun,
+	}
+	if *dryRun && *dryRunOutput != "" {
+		f, err := os.Create(*dryRunOutput)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer f.Close()
+		opts.DryRunOutput = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	running := &runningSyncer{standby: *leaderElect}
+	if *httpAddress != "" {
+		go serve(*httpAddress, running)
+	}
+
+	if *once {
+		os.Exit(runOnce(ctx, fromConfig, toConfig, syncedResourceTypes, opts, running))
+	}
+
+	run := func(ctx context.Context) {
+		s, err := syncer.StartSyncer(fromConfig, toConfig, sets.NewString(syncedResourceTypes...), *clusterID, numThreads, opts)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		running.set(s)
+		klog.Infoln("Starting workers")
+
+		<-ctx.Done()
+		klog.Infoln("Stopping workers")
+		s.Stop()
+	}
+
+	if !*leaderElect {
+		run(ctx)
+		return
+	}
+
+	if *leaseNamespace == "" {
+		*leaseNamespace = os.Getenv(syncer.SyncerNamespaceKey)
+	}
+	if *leaseNamespace == "" {
+		klog.Fatal("-leader_election_namespace is required outside of a syncer pod")
+	}
+	if *leaseName == "" {
+		*leaseName = "kcp-syncer-" + *clusterID
+	}
+	// The identity is the pod name, so that the cluster controller can check the health of the leader.
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: *leaseNamespace,
+				Name:      *leaseName,
+			},
+			Client: kubernetes.NewForConfigOrDie(toConfig).CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		},
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				klog.Infof("%s stopped leading", identity)
+			},
+		},
+	})
 }
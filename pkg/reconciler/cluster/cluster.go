@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
@@ -23,6 +25,19 @@ const (
 	numSyncerThreads = 2
 )
 
+// pushSyncer is a syncer run by the cluster controller in push mode.
+type pushSyncer struct {
+	*syncer.Syncer
+	// kubeConfigHash is the hash of the kubeconfig of the cluster the syncer was started with.
+	kubeConfigHash string
+	// logicalCluster is the logical cluster synced, whose MultiSyncer runs the syncer.
+	logicalCluster string
+}
+
+func kubeConfigHash(kubeConfig string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(kubeConfig)))
+}
+
 func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Cluster) error {
 	klog.Infof("reconciling cluster %q", cluster.Name)
 
@@ -91,7 +106,13 @@ func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Clu
 		}.String())
 	}
 
-	if !sets.NewString(cluster.Status.SyncedResources...).Equal(groupResources) {
+	if existingSyncer := c.syncers[cluster.Name]; existingSyncer != nil && existingSyncer.kubeConfigHash != kubeConfigHash(cluster.Spec.KubeConfig) {
+		klog.Infof("kubeconfig of cluster %q changed, restarting its syncer", cluster.Name)
+		c.stopSyncer(cluster.Name)
+	}
+
+	// A syncer is started in push mode when the resources change, or when it is not running anymore.
+	if !sets.NewString(cluster.Status.SyncedResources...).Equal(groupResources) || (c.syncerMode == SyncerModePush && c.syncers[cluster.Name] == nil) {
 		kubeConfig := c.kubeconfig.DeepCopy()
 		if _, exists := kubeConfig.Contexts[logicalCluster]; !exists {
 			klog.Errorf("error installing syncer: no context with the name of the expected cluster: %s", logicalCluster)
@@ -103,14 +124,38 @@ func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Clu
 
 		switch c.syncerMode {
 		case SyncerModePush:
-			kubeConfig.CurrentContext = logicalCluster
-			upstream, err := clientcmd.NewNonInteractiveClientConfig(*kubeConfig, logicalCluster, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
-			if err != nil {
-				klog.Errorf("error getting kcp kubeconfig: %v", err)
-				cluster.Status.SetConditionReady(corev1.ConditionFalse,
-					"ErrorStartingSyncer",
-					fmt.Sprintf("Error starting syncer: %v", err))
-				return nil // Don't retry.
+			if existingSyncer := c.syncers[cluster.Name]; existingSyncer != nil {
+				// The running syncer discovers the new resource types by itself,
+				// so there is no need to restart it.
+				existingSyncer.SetResources(groupResources)
+				klog.Info("syncer ready!")
+				cluster.Status.SetConditionReady(corev1.ConditionTrue,
+					"SyncerReady",
+					"Syncer ready")
+				break
+			}
+
+			multiSyncer := c.multiSyncers[logicalCluster]
+			if multiSyncer == nil {
+				upstream, err := c.upstreamConfig(logicalCluster)
+				if err != nil {
+					klog.Errorf("error getting kcp kubeconfig: %v", err)
+					cluster.Status.SetConditionReady(corev1.ConditionFalse,
+						"ErrorStartingSyncer",
+						fmt.Sprintf("Error starting syncer: %v", err))
+					return nil // Don't retry.
+				}
+				opts := c.syncerOptions
+				opts.LogicalCluster = logicalCluster
+				multiSyncer, err = syncer.NewMultiSyncer(upstream, numSyncerThreads, opts)
+				if err != nil {
+					klog.Errorf("error starting syncer in push mode: %v", err)
+					cluster.Status.SetConditionReady(corev1.ConditionFalse,
+						"ErrorStartingSyncer",
+						fmt.Sprintf("Error starting syncer: %v", err))
+					return err
+				}
+				c.multiSyncers[logicalCluster] = multiSyncer
 			}
 
 			downstream, err := clientcmd.RESTConfigFromKubeConfig([]byte(cluster.Spec.KubeConfig))
@@ -122,7 +167,7 @@ func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Clu
 				return nil // Don't retry.
 			}
 
-			newSyncer, err := syncer.StartSyncer(upstream, downstream, groupResources, cluster.Name, numSyncerThreads)
+			newSyncer, err := multiSyncer.StartSyncer(downstream, groupResources, cluster.Name)
 			if err != nil {
 				klog.Errorf("error starting syncer in push mode: %v", err)
 				cluster.Status.SetConditionReady(corev1.ConditionFalse,
@@ -131,11 +176,7 @@ func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Clu
 				return err
 			}
 
-			oldSyncer := c.syncers[cluster.Name]
-			c.syncers[cluster.Name] = newSyncer
-			if oldSyncer != nil {
-				oldSyncer.Stop()
-			}
+			c.syncers[cluster.Name] = &pushSyncer{Syncer: newSyncer, kubeConfigHash: kubeConfigHash(cluster.Spec.KubeConfig), logicalCluster: logicalCluster}
 
 			klog.Info("syncer ready!")
 			cluster.Status.SetConditionReady(corev1.ConditionTrue,
@@ -151,7 +192,7 @@ func (c *Controller) reconcile(ctx context.Context, cluster *clusterv1alpha1.Clu
 					fmt.Sprintf("Error installing syncer: %v", err))
 				return nil // Don't retry.
 			}
-			if err := installSyncer(ctx, client, c.syncerImage, string(bytes), cluster.Name, logicalCluster, groupResources.List()); err != nil {
+			if err := installSyncer(ctx, client, c.syncerImage, string(bytes), cluster.Name, logicalCluster, groupResources.List(), c.syncerOptions, c.syncerReplicas); err != nil {
 				klog.Errorf("error installing syncer: %v", err)
 				cluster.Status.SetConditionReady(corev1.ConditionFalse,
 					"ErrorInstallingSyncer",
@@ -217,23 +258,60 @@ func (c *Controller) cleanup(ctx context.Context, deletedCluster *clusterv1alpha
 		cfg, err := clientcmd.RESTConfigFromKubeConfig([]byte(deletedCluster.Spec.KubeConfig))
 		if err != nil {
 			klog.Errorf("invalid kubeconfig: %v", err)
-			return
+			break
 		}
 		client, err := kubernetes.NewForConfig(cfg)
 		if err != nil {
 			klog.Errorf("error creating client: %v", err)
-			return
+			break
 		}
 
 		uninstallSyncer(ctx, client)
 	case SyncerModePush:
-		s, ok := c.syncers[deletedCluster.Name]
-		if !ok {
+		if _, ok := c.syncers[deletedCluster.Name]; !ok {
 			klog.Errorf("could not find syncer for cluster %q", deletedCluster.Name)
-			return
+			break
 		}
-		klog.Infof("stopping syncer for cluster %q", deletedCluster.Name)
+		c.stopSyncer(deletedCluster.Name)
+	case SyncerModeNone:
+		return
+	}
+
+	// The syncer of the cluster is gone, and can't finalize the deletion of the upstream objects anymore.
+	upstream, err := c.upstreamConfig(deletedCluster.GetClusterName())
+	if err != nil {
+		klog.Errorf("error getting kcp kubeconfig: %v", err)
+		return
+	}
+	if err := syncer.RemoveFinalizers(ctx, upstream, deletedCluster.Status.SyncedResources, c.syncerOptions.ClusterScopedResources, deletedCluster.Name); err != nil {
+		klog.Errorf("error removing the syncer finalizers of cluster %q: %v", deletedCluster.Name, err)
+	}
+}
+
+// stopSyncer stops the syncer of a cluster in push mode, and the MultiSyncer of its logical cluster
+// along with its last syncer.
+func (c *Controller) stopSyncer(clusterName string) {
+	klog.Infof("stopping syncer for cluster %q", clusterName)
+	s := c.syncers[clusterName]
+	delete(c.syncers, clusterName)
+	multiSyncer := c.multiSyncers[s.logicalCluster]
+	if multiSyncer == nil {
 		s.Stop()
-		delete(c.syncers, deletedCluster.Name)
+		return
+	}
+	if multiSyncer.StopSyncer(clusterName) == 0 {
+		klog.Infof("stopping the shared informers of logical cluster %q", s.logicalCluster)
+		multiSyncer.Stop()
+		delete(c.multiSyncers, s.logicalCluster)
+	}
+}
+
+// upstreamConfig returns the configuration of the syncers of the clusters of a logical cluster, to connect to kcp.
+func (c *Controller) upstreamConfig(logicalCluster string) (*rest.Config, error) {
+	kubeConfig := c.kubeconfig.DeepCopy()
+	if _, exists := kubeConfig.Contexts[logicalCluster]; !exists {
+		return nil, fmt.Errorf("no context with the name of the expected cluster: %s", logicalCluster)
 	}
+	kubeConfig.CurrentContext = logicalCluster
+	return clientcmd.NewNonInteractiveClientConfig(*kubeConfig, logicalCluster, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
 }
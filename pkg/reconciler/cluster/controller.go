@@ -63,7 +63,7 @@ func GetLocationInLogicalClusterIndexKey(location, clusterName string) string {
 // server it reaches using the REST client.
 //
 // When new Clusters are found, the syncer will be run there using the given image.
-func NewController(cfg *rest.Config, syncerImage string, kubeconfig clientcmdapi.Config, resourcesToSync []string, syncerMode SyncerMode) (*Controller, error) {
+func NewController(cfg *rest.Config, syncerImage string, kubeconfig clientcmdapi.Config, resourcesToSync []string, syncerMode SyncerMode, syncerOptions syncer.Options, syncerReplicas int32) (*Controller, error) {
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	stopCh := make(chan struct{}) // TODO: hook this up to SIGTERM/SIGINT
 
@@ -109,7 +109,10 @@ func NewController(cfg *rest.Config, syncerImage string, kubeconfig clientcmdapi
 		stopCh:                       stopCh,
 		resourcesToSync:              resourcesToSync,
 		syncerMode:                   syncerMode,
-		syncers:                      map[string]*syncer.Syncer{},
+		syncerOptions:                syncerOptions,
+		syncerReplicas:               syncerReplicas,
+		syncers:                      map[string]*pushSyncer{},
+		multiSyncers:                 map[string]*syncer.MultiSyncer{},
 		apiImporters:                 map[string]*APIImporter{},
 		genericControlPlaneResources: genericControlPlaneResources,
 	}
@@ -166,7 +169,10 @@ type Controller struct {
 	stopCh                       chan struct{}
 	resourcesToSync              []string
 	syncerMode                   SyncerMode
-	syncers                      map[string]*syncer.Syncer
+	syncerOptions                syncer.Options
+	syncerReplicas               int32
+	syncers                      map[string]*pushSyncer
+	multiSyncers                 map[string]*syncer.MultiSyncer
 	apiImporters                 map[string]*APIImporter
 	genericControlPlaneResources []schema.GroupVersionResource
 }
@@ -3,17 +3,21 @@ package cluster
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
 
 	"github.com/kcp-dev/kcp/pkg/syncer"
 )
@@ -35,7 +39,7 @@ func syncerConfigMapName(logicalCluster string) string {
 // installSyncer installs the syncer image on the target cluster.
 //
 // It takes the syncer image name to run, and the kubeconfig of the kcp
-func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage, kubeconfig, clusterID, logicalCluster string, groupResourcesToSync []string) error {
+func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage, kubeconfig, clusterID, logicalCluster string, groupResourcesToSync []string, syncerOptions syncer.Options, replicas int32) error {
 	// Create Namespace
 	if _, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
@@ -59,29 +63,64 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 
 	resourcesWithStatus := sets.NewString()
 	apiGroups := sets.NewString()
+	rbacResources := sets.NewString()
 
 	for _, groupResourceToSync := range groupResourcesToSync {
 		gr := schema.ParseGroupResource(groupResourceToSync)
 		resourcesWithStatus.Insert(gr.Resource, gr.Resource+"/status")
 		apiGroups.Insert(gr.Group)
+		if gr.Group == rbacv1.GroupName && (gr.Resource == "clusterroles" || gr.Resource == "roles") {
+			rbacResources.Insert(gr.Resource)
+		}
+	}
+
+	verbs := []string{"create", "update", "get", "delete", "list", "watch"}
+	if syncerOptions.ServerSideApply {
+		verbs = append(verbs, "patch")
+	}
+
+	rules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"create", "update", "get", "delete", "list", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+		},
+		{
+			Verbs:     verbs,
+			Resources: resourcesWithStatus.List(),
+			APIGroups: apiGroups.List(),
+		},
+	}
+
+	if syncerOptions.SyncDependencies {
+		rules = append(rules, rbacv1.PolicyRule{
+			Verbs:     verbs,
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "secrets", "serviceaccounts", "persistentvolumeclaims"},
+		})
+	}
+	if syncerOptions.SyncEvents {
+		rules = append(rules, rbacv1.PolicyRule{
+			Verbs:     []string{"get", "list", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+		})
+	}
+
+	// Syncing roles requires to be allowed to grant any permission they contain.
+	if rbacResources.Len() > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			Verbs:     []string{"escalate", "bind"},
+			APIGroups: []string{rbacv1.GroupName},
+			Resources: rbacResources.List(),
+		})
 	}
 
 	clusterRole := &rbacv1.ClusterRole{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: syncerSAName,
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				Verbs:     []string{"create"},
-				APIGroups: []string{""},
-				Resources: []string{"namespaces"},
-			},
-			{
-				Verbs:     []string{"create", "update", "get"},
-				Resources: resourcesWithStatus.List(),
-				APIGroups: apiGroups.List(),
-			},
-		},
+		Rules: rules,
 	}
 	if _, err := client.RbacV1().ClusterRoles().Create(ctx, clusterRole, metav1.CreateOptions{}); err != nil {
 		if !k8serrors.IsAlreadyExists(err) {
@@ -121,8 +160,62 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 		return err
 	}
 
-	// Populate a ConfigMap with the kubeconfig to reach the kcp, to be
-	// mounted into the syncer's Pod.
+	// Create Role and RoleBinding for the leader election Leases of the syncers.
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: syncerNS,
+			Name:      syncerSAName,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"create", "update", "get"},
+				APIGroups: []string{coordinationv1.GroupName},
+				Resources: []string{"leases"},
+			},
+		},
+	}
+	if _, err := client.RbacV1().Roles(syncerNS).Create(ctx, role, metav1.CreateOptions{}); err != nil {
+		if !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+		existing, err := client.RbacV1().Roles(syncerNS).Get(ctx, role.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !equality.Semantic.DeepEqual(existing.Rules, role.Rules) {
+			role.ResourceVersion = existing.ResourceVersion
+			if _, err := client.RbacV1().Roles(syncerNS).Update(ctx, role, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := client.RbacV1().RoleBindings(syncerNS).Create(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: syncerNS,
+			Name:      syncerSAName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      syncerSAName,
+				Namespace: syncerNS,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     syncerSAName,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}, metav1.CreateOptions{}); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	// Populate a ConfigMap with the kubeconfig to reach the kcp, and the
+	// transformations if any, to be mounted into the syncer's Pod.
+	configMapItems := []corev1.KeyToPath{{
+		Key: "kubeconfig", Path: "kubeconfig",
+	}}
 	configMap := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: syncerNS,
@@ -132,6 +225,16 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 			"kubeconfig": kubeconfig,
 		},
 	}
+	if len(syncerOptions.Transformations) > 0 {
+		transformations, err := yaml.Marshal(syncerOptions.Transformations)
+		if err != nil {
+			return err
+		}
+		configMap.Data["transformations"] = string(transformations)
+		configMapItems = append(configMapItems, corev1.KeyToPath{
+			Key: "transformations", Path: "transformations",
+		})
+	}
 	if _, err := client.CoreV1().ConfigMaps(syncerNS).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
 		if k8serrors.IsAlreadyExists(err) {
 			if configMap, err = client.CoreV1().ConfigMaps(syncerNS).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
@@ -144,27 +247,61 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 
 	args := []string{
 		"-cluster", clusterID,
+		"-logical_cluster", logicalCluster,
 		"-from_kubeconfig", "/kcp/kubeconfig",
+		"-leader_elect",
+		"-leader_election_id", syncerWorkloadName(logicalCluster),
+	}
+	if syncerOptions.ServerSideApply {
+		args = append(args, "-server_side_apply")
+	}
+	if syncerOptions.NamespaceMapping != "" {
+		args = append(args, "-namespace_mapping", syncerOptions.NamespaceMapping)
+	}
+	if len(syncerOptions.ClusterScopedResources) > 0 {
+		args = append(args, "-cluster_scoped_resources", strings.Join(syncerOptions.ClusterScopedResources, ","))
+	}
+	if len(syncerOptions.Transformations) > 0 {
+		args = append(args, "-transformations", "/kcp/transformations")
+	}
+	if syncerOptions.DriftPolicy != "" {
+		args = append(args, "-drift_policy", syncerOptions.DriftPolicy)
+	}
+	if syncerOptions.OrphanPolicy != "" {
+		args = append(args, "-orphan_policy", syncerOptions.OrphanPolicy)
+	}
+	if syncerOptions.AdoptPolicy != "" {
+		args = append(args, "-adopt_policy", syncerOptions.AdoptPolicy)
+	}
+	if syncerOptions.SyncDependencies {
+		args = append(args, "-sync_dependencies")
+	}
+	if syncerOptions.SyncEvents {
+		args = append(args, "-sync_events")
+	}
+	if len(syncerOptions.ResourceWorkers) > 0 {
+		var resourceWorkers []string
+		for _, resource := range sets.StringKeySet(syncerOptions.ResourceWorkers).List() {
+			resourceWorkers = append(resourceWorkers, fmt.Sprintf("%s=%d", resource, syncerOptions.ResourceWorkers[resource]))
+		}
+		args = append(args, "-resource_workers", strings.Join(resourceWorkers, ","))
 	}
 	args = append(args, groupResourcesToSync...)
 
-	var one int32 = 1
-	// Create or Update Deployment
+	// Create or Update Deployment.
+	// The replicas elect a leader, so that they can be rolled out one by one.
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: syncerNS,
 			Name:      syncerWorkloadName(logicalCluster),
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &one,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": syncerWorkloadName(logicalCluster),
 				},
 			},
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RecreateDeploymentStrategyType,
-			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
@@ -179,6 +316,29 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 						Name:  "syncer",
 						Image: syncerImage,
 						Args:  args,
+						Ports: []corev1.ContainerPort{{
+							Name:          "http",
+							ContainerPort: 8080,
+						}},
+						LivenessProbe: &corev1.Probe{
+							Handler: corev1.Handler{
+								HTTPGet: &corev1.HTTPGetAction{
+									Path: "/healthz",
+									Port: intstr.FromString("http"),
+								},
+							},
+							InitialDelaySeconds: 30,
+							PeriodSeconds:       10,
+						},
+						ReadinessProbe: &corev1.Probe{
+							Handler: corev1.Handler{
+								HTTPGet: &corev1.HTTPGetAction{
+									Path: "/readyz",
+									Port: intstr.FromString("http"),
+								},
+							},
+							PeriodSeconds: 10,
+						},
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      "kubeconfig",
 							MountPath: "/kcp",
@@ -192,6 +352,13 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 									FieldPath: "metadata.namespace",
 								},
 							},
+						}, {
+							Name: "POD_NAME",
+							ValueFrom: &corev1.EnvVarSource{
+								FieldRef: &corev1.ObjectFieldSelector{
+									FieldPath: "metadata.name",
+								},
+							},
 						}},
 					}},
 					Volumes: []corev1.Volume{{
@@ -201,9 +368,7 @@ func installSyncer(ctx context.Context, client kubernetes.Interface, syncerImage
 								LocalObjectReference: corev1.LocalObjectReference{
 									Name: syncerConfigMapName(logicalCluster),
 								},
-								Items: []corev1.KeyToPath{{
-									Key: "kubeconfig", Path: "kubeconfig",
-								}},
+								Items: configMapItems,
 							},
 						},
 					}},
@@ -234,19 +399,33 @@ func uninstallSyncer(ctx context.Context, client kubernetes.Interface) {
 }
 
 func healthcheckSyncer(ctx context.Context, client kubernetes.Interface, logicalCluster string) error {
-	pods, err := client.CoreV1().Pods(syncerNS).List(ctx, metav1.ListOptions{LabelSelector: "app=" + syncerConfigMapName(logicalCluster)})
+	// Only the leader syncer replica syncs: check the pod holding the leader election Lease.
+	lease, err := client.CoordinationV1().Leases(syncerNS).Get(ctx, syncerWorkloadName(logicalCluster), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("Syncer pod not ready: no syncer leader elected")
+	}
 	if err != nil {
 		return err
 	}
-	if len(pods.Items) == 0 {
-		return fmt.Errorf("Syncer pod not ready: not syncer pod found")
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return fmt.Errorf("Syncer pod not ready: no syncer leader elected")
 	}
-	if len(pods.Items) > 1 {
-		return fmt.Errorf("Syncer pod not ready: there should be only 1 syncer pod")
+	pod, err := client.CoreV1().Pods(syncerNS).Get(ctx, *lease.Spec.HolderIdentity, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("Syncer pod not ready: leader syncer pod %s not found", *lease.Spec.HolderIdentity)
+	}
+	if err != nil {
+		return err
 	}
-	pod := pods.Items[0]
 	if pod.Status.Phase != corev1.PodRunning {
 		return fmt.Errorf("Syncer pod not ready: %s", pod.Status.Phase)
 	}
+	// The Ready condition of the pod reflects the /readyz endpoint of the syncer,
+	// which checks informer sync, connectivity and queue health.
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status != corev1.ConditionTrue {
+			return fmt.Errorf("Syncer pod not ready: readiness probe failing: %s", condition.Message)
+		}
+	}
 	return nil
 }
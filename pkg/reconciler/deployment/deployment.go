@@ -11,6 +11,8 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
+
+	"github.com/kcp-dev/kcp/pkg/syncer"
 )
 
 const (
@@ -21,6 +23,12 @@ const (
 func (c *Controller) reconcile(ctx context.Context, deployment *appsv1.Deployment) error {
 	klog.Infof("reconciling deployment %q", deployment.Name)
 
+	// Deployments assigned to several clusters are synced to each of them as is.
+	if _, assigned := deployment.Annotations[syncer.AssignedLocationsAnnotation]; assigned {
+		klog.V(2).Infof("deployment %q is assigned to locations, not splitting it", deployment.Name)
+		return nil
+	}
+
 	if deployment.Labels == nil || deployment.Labels[clusterLabel] == "" {
 		// This is a root deployment; get its leafs.
 		sel, err := labels.Parse(fmt.Sprintf("%s=%s", ownedByLabel, deployment.Name))
@@ -39,6 +47,14 @@ func (c *Controller) reconcile(ctx context.Context, deployment *appsv1.Deploymen
 		}
 
 	} else {
+		// Leafs created before location labels are only assigned to their cluster by the cluster label.
+		if cluster := deployment.Labels[clusterLabel]; deployment.Labels[syncer.LocationLabel(cluster)] == "" {
+			leaf := deployment.DeepCopy()
+			syncer.AssignLocation(leaf, cluster)
+			_, err := c.client.Deployments(leaf.Namespace).Update(ctx, leaf, metav1.UpdateOptions{})
+			return err
+		}
+
 		rootDeploymentName := deployment.Labels[ownedByLabel]
 		// A leaf deployment was updated; get others and aggregate status.
 		sel, err := labels.Parse(fmt.Sprintf("%s=%s", ownedByLabel, rootDeploymentName))
@@ -84,6 +100,17 @@ func (c *Controller) reconcile(ctx context.Context, deployment *appsv1.Deploymen
 			rootDeployment.Status.UnavailableReplicas += o.Status.UnavailableReplicas
 		}
 
+		// The root deployment is observed once every leaf was applied to its cluster, and observed there.
+		converged := len(others) > 0
+		for _, o := range others {
+			if !syncer.SpecSynced(o, o.Labels[clusterLabel]) || o.Status.ObservedGeneration < o.Generation {
+				converged = false
+			}
+		}
+		if converged {
+			rootDeployment.Status.ObservedGeneration = rootDeployment.Generation
+		}
+
 		// Cheat and set the root .status.conditions to the first leaf's .status.conditions.
 		// TODO: do better.
 		if len(others) > 0 {
@@ -137,6 +164,7 @@ func (c *Controller) createLeafs(ctx context.Context, root *appsv1.Deployment) e
 		}
 		vd.Labels[clusterLabel] = cl.Name
 		vd.Labels[ownedByLabel] = root.Name
+		syncer.AssignLocation(vd, cl.Name)
 
 		replicasToSet := replicasEach
 		if index == 0 {
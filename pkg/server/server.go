@@ -36,6 +36,7 @@ import (
 	"github.com/kcp-dev/kcp/pkg/etcd"
 	"github.com/kcp-dev/kcp/pkg/reconciler/apiresource"
 	"github.com/kcp-dev/kcp/pkg/reconciler/cluster"
+	"github.com/kcp-dev/kcp/pkg/syncer"
 )
 
 const resyncPeriod = 10 * time.Hour
@@ -234,6 +235,8 @@ func (s *Server) Run(ctx context.Context) error {
 					*kubeconfig,
 					s.cfg.ResourcesToSync,
 					syncerMode,
+					syncer.Options{},
+					1,
 				)
 				if err != nil {
 					return err
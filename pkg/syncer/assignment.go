@@ -0,0 +1,145 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// LocationLabelPrefix is the prefix of the labels, suffixed with a cluster ID, which assign an upstream object
+	// to clusters, e.g. kcp.dev/location-us-east1. An object carries one label per cluster it is synced to,
+	// so that the objects assigned to a cluster can be selected server-side, with an exists selector.
+	LocationLabelPrefix = "kcp.dev/location-"
+
+	// AssignedLocationsAnnotation lists the clusters an upstream object is assigned to, as a JSON array of cluster IDs,
+	// e.g. ["us-east1", "us-west1"]. It is written along with the location labels by SetAssignedLocations.
+	AssignedLocationsAnnotation = "kcp.io/assigned-locations"
+
+	// clusterLabel assigned upstream objects to a single cluster before location labels and annotations.
+	// It is still honored, so that objects keep syncing until they are assigned with them.
+	clusterLabel = "kcp.dev/cluster"
+
+	// locationStatusAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+	// which holds the status of the downstream object of an upstream object assigned to several locations.
+	locationStatusAnnotationPrefix = "kcp.dev/location-status."
+)
+
+// LocationLabel returns the label assigning an upstream object to the cluster.
+func LocationLabel(clusterID string) string {
+	return LocationLabelPrefix + clusterID
+}
+
+// AssignLocation assigns the upstream object to the cluster, in addition to its other locations.
+func AssignLocation(obj metav1.Object, clusterID string) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[LocationLabel(clusterID)] = "true"
+	obj.SetLabels(labels)
+}
+
+// SetAssignedLocations assigns the upstream object to the given clusters only, with location labels,
+// and lists them in the AssignedLocationsAnnotation.
+func SetAssignedLocations(obj metav1.Object, clusterIDs []string) error {
+	locations := sets.NewString(clusterIDs...)
+	value, err := json.Marshal(locations.List())
+	if err != nil {
+		return err
+	}
+
+	labels := obj.GetLabels()
+	for key := range labels {
+		if strings.HasPrefix(key, LocationLabelPrefix) {
+			delete(labels, key)
+		}
+	}
+	obj.SetLabels(labels)
+	for _, clusterID := range locations.List() {
+		AssignLocation(obj, clusterID)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AssignedLocationsAnnotation] = string(value)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// assignedLocations returns the cluster IDs the upstream object is assigned to, from its location labels,
+// its AssignedLocationsAnnotation and its cluster label.
+func assignedLocations(meta metav1.Object) sets.String {
+	locations := sets.NewString()
+	for key := range meta.GetLabels() {
+		if strings.HasPrefix(key, LocationLabelPrefix) {
+			locations.Insert(strings.TrimPrefix(key, LocationLabelPrefix))
+		}
+	}
+	if value, found := meta.GetAnnotations()[AssignedLocationsAnnotation]; found {
+		var clusterIDs []string
+		if err := json.Unmarshal([]byte(value), &clusterIDs); err != nil {
+			klog.V(2).Infof("Invalid %s annotation on %s/%s: %v", AssignedLocationsAnnotation, meta.GetNamespace(), meta.GetName(), err)
+		}
+		locations.Insert(clusterIDs...)
+	}
+	if clusterID := meta.GetLabels()[clusterLabel]; clusterID != "" {
+		locations.Insert(clusterID)
+	}
+	return locations
+}
+
+// isAssigned returns whether the upstream object is assigned to the cluster.
+func (c *Controller) isAssigned(obj interface{}) bool {
+	if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+		obj = tombstone.Obj
+	}
+	meta, isMeta := obj.(metav1.Object)
+	if !isMeta {
+		return false
+	}
+	return assignedLocations(meta).Has(c.clusterID)
+}
+
+// withAssignment wraps the UpsertFunc and DeleteFunc of the spec syncer, so that an upstream object
+// which is not assigned to the cluster anymore is deleted downstream, as if it was deleted upstream.
+func withAssignment(upsertFn UpsertFunc, deleteFn DeleteFunc) (UpsertFunc, DeleteFunc) {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+		if !c.isAssigned(unstrob) {
+			return deleteFn(c, ctx, gvr, namespace, unstrob)
+		}
+		return upsertFn(c, ctx, gvr, namespace, unstrob)
+	}, deleteFn
+}
+
+// locationStatus returns the status of the cluster recorded by setLocationStatus, or nil if there is none.
+func (c *Controller) locationStatus(upstreamObj *unstructured.Unstructured) map[string]interface{} {
+	value, found := upstreamObj.GetAnnotations()[locationStatusAnnotationPrefix+c.clusterID]
+	if !found {
+		return nil
+	}
+	status := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &status); err != nil {
+		return nil
+	}
+	return status
+}
+
+// setLocationStatus records the status of the downstream object in an annotation of an upstream object
+// assigned to several locations, so that the status of the locations don't overwrite each other.
+func (c *Controller) setLocationStatus(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj, downstreamObj *unstructured.Unstructured) error {
+	status, err := json.Marshal(downstreamObj.Object["status"])
+	if err != nil {
+		return err
+	}
+	return setAnnotation(ctx, c.getClient(gvr, upstreamObj.GetNamespace()), upstreamObj, locationStatusAnnotationPrefix+c.clusterID, string(status))
+}
@@ -0,0 +1,62 @@
+package syncer
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAssignedLocations(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        []string
+	}{
+		{name: "unassigned"},
+		{name: "location label", labels: map[string]string{"kcp.dev/location-east": "true"}, want: []string{"east"}},
+		{
+			name:   "location labels",
+			labels: map[string]string{"kcp.dev/location-east": "true", "kcp.dev/location-west": "true", "app": "web"},
+			want:   []string{"east", "west"},
+		},
+		{name: "cluster label", labels: map[string]string{"kcp.dev/cluster": "east"}, want: []string{"east"}},
+		{name: "annotation only", annotations: map[string]string{AssignedLocationsAnnotation: `["east","west"]`}, want: []string{"east", "west"}},
+		{name: "invalid annotation", annotations: map[string]string{AssignedLocationsAnnotation: `east`}},
+		{
+			name:        "all sources",
+			labels:      map[string]string{"kcp.dev/location-east": "true", "kcp.dev/cluster": "north"},
+			annotations: map[string]string{AssignedLocationsAnnotation: `["west"]`},
+			want:        []string{"east", "north", "west"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := &metav1.ObjectMeta{Labels: tc.labels, Annotations: tc.annotations}
+			got := assignedLocations(meta).List()
+			if len(got) != len(tc.want) {
+				t.Fatalf("assignedLocations() = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("assignedLocations() = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetAssignedLocations(t *testing.T) {
+	meta := &metav1.ObjectMeta{Labels: map[string]string{"kcp.dev/location-north": "true", "app": "web"}}
+	if err := SetAssignedLocations(meta, []string{"west", "east"}); err != nil {
+		t.Fatalf("SetAssignedLocations() = %v", err)
+	}
+	if got := assignedLocations(meta).List(); len(got) != 2 || got[0] != "east" || got[1] != "west" {
+		t.Errorf("assignedLocations() = %v, want [east west]", got)
+	}
+	if meta.Labels["app"] != "web" {
+		t.Errorf("SetAssignedLocations() removed other labels: %v", meta.Labels)
+	}
+	if got, want := meta.Annotations[AssignedLocationsAnnotation], `["east","west"]`; got != want {
+		t.Errorf("%s = %s, want %s", AssignedLocationsAnnotation, got, want)
+	}
+}
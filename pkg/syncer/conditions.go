@@ -0,0 +1,71 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// setCondition sets a condition of the given type in the .status.conditions of the object.
+// It either overwrites the existing one or creates a new one, and returns whether the object changed.
+func setCondition(unstrob *unstructured.Unstructured, conditionType, status, reason, message string) (bool, error) {
+	conditions, _, err := unstructured.NestedSlice(unstrob.Object, "status", "conditions")
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	newCondition := map[string]interface{}{
+		"type":               conditionType,
+		"status":             status,
+		"reason":             reason,
+		"message":            message,
+		"lastTransitionTime": now,
+	}
+
+	found := false
+	for i, c := range conditions {
+		existing, ok := c.(map[string]interface{})
+		if !ok || existing["type"] != conditionType {
+			continue
+		}
+		found = true
+		if existing["status"] == status && existing["reason"] == reason && existing["message"] == message {
+			return false, nil
+		}
+		if existing["status"] == status {
+			newCondition["lastTransitionTime"] = existing["lastTransitionTime"]
+		}
+		conditions[i] = newCondition
+	}
+	if !found {
+		conditions = append(conditions, newCondition)
+	}
+
+	if err := unstructured.SetNestedSlice(unstrob.Object, conditions, "status", "conditions"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setFromCondition sets a condition on the object on the "from" side, and patches its status if it changed.
+func (c *Controller) setFromCondition(ctx context.Context, gvr schema.GroupVersionResource, unstrob *unstructured.Unstructured, conditionType, status, reason, message string) error {
+	return c.updateCondition(ctx, gvr, c.getFromClient(gvr, unstrob.GetNamespace()), unstrob, conditionType, status, reason, message)
+}
+
+// updateCondition sets a condition on the upstream object, and patches its status with the client if it changed.
+func (c *Controller) updateCondition(ctx context.Context, gvr schema.GroupVersionResource, client dynamic.ResourceInterface, unstrob *unstructured.Unstructured, conditionType, status, reason, message string) error {
+	modified := unstrob.DeepCopy()
+	changed, err := setCondition(modified, conditionType, status, reason, message)
+	if err != nil || !changed {
+		return err
+	}
+	patch, err := statusPatch(unstrob, modified)
+	if err != nil || patch == nil {
+		return err
+	}
+	return c.patchStatus(ctx, gvr, client, unstrob.GetName(), patch)
+}
@@ -0,0 +1,230 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+const (
+	// syncerFinalizerPrefix is the prefix of the finalizer, suffixed with the cluster ID,
+	// which the spec syncer puts on upstream objects to guard the deletion of their downstream copy.
+	syncerFinalizerPrefix = "kcp.dev/syncer-"
+
+	// deletionRecheckInterval is how often the spec syncer checks whether a downstream object is gone.
+	deletionRecheckInterval = 5 * time.Second
+	// stuckDeletionTimeout is how long a downstream object can take to be deleted before it is reported as stuck.
+	stuckDeletionTimeout = 5 * time.Minute
+
+	// DeletionStuckConditionType is the condition set on an upstream object whose downstream copy could not be deleted.
+	DeletionStuckConditionType = "DeletionStuck"
+)
+
+func (c *Controller) finalizerName() string {
+	return syncerFinalizerPrefix + c.clusterID
+}
+
+// withDeletionFinalizer wraps an UpsertFunc so that the syncer finalizer is added to the upstream
+// object before it is synced downstream, and the deletion of upstream objects marked for deletion
+// is propagated downstream before the finalizer is removed.
+func withDeletionFinalizer(upsertFn UpsertFunc) UpsertFunc {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+		if unstrob.GetDeletionTimestamp() != nil {
+			return c.finalizeDeletion(ctx, gvr, namespace, unstrob)
+		}
+		if err := c.ensureFinalizer(ctx, gvr, unstrob); err != nil {
+			klog.Errorf("Adding finalizer to upstream resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			return err
+		}
+		return upsertFn(c, ctx, gvr, namespace, unstrob)
+	}
+}
+
+// ensureFinalizer adds the syncer finalizer to the upstream object, if not already there.
+func (c *Controller) ensureFinalizer(ctx context.Context, gvr schema.GroupVersionResource, unstrob *unstructured.Unstructured) error {
+	finalizers := unstrob.GetFinalizers()
+	if contains(finalizers, c.finalizerName()) {
+		return nil
+	}
+	return patchFinalizers(ctx, c.getFromClient(gvr, unstrob.GetNamespace()), unstrob, append(finalizers, c.finalizerName()))
+}
+
+// removeFinalizer removes the syncer finalizer from the upstream object, if it is there.
+func (c *Controller) removeFinalizer(ctx context.Context, gvr schema.GroupVersionResource, unstrob *unstructured.Unstructured) error {
+	finalizers := withoutFinalizer(unstrob.GetFinalizers(), c.finalizerName())
+	if len(finalizers) == len(unstrob.GetFinalizers()) {
+		return nil
+	}
+	err := patchFinalizers(ctx, c.getFromClient(gvr, unstrob.GetNamespace()), unstrob, finalizers)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// withoutFinalizer returns the finalizers, apart from the given one.
+func withoutFinalizer(finalizers []string, name string) []string {
+	var result []string
+	for _, finalizer := range finalizers {
+		if finalizer != name {
+			result = append(result, finalizer)
+		}
+	}
+	return result
+}
+
+// patchFinalizers sets the finalizers of the upstream object with a merge patch, which fails with a conflict
+// if the object changed since it was read, instead of overwriting the other changes.
+func patchFinalizers(ctx context.Context, client dynamic.ResourceInterface, unstrob *unstructured.Unstructured, finalizers []string) error {
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": unstrob.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Patch(ctx, unstrob.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// RemoveFinalizers removes the finalizer of the syncer of a cluster from the upstream objects of the synced
+// resource types, once the cluster is removed and nothing will finalize the deletion of these objects anymore.
+func RemoveFinalizers(ctx context.Context, upstream *rest.Config, resources []string, clusterScopedResources []string, clusterID string) error {
+	upstreamDiscovery, err := discovery.NewDiscoveryClientForConfig(upstream)
+	if err != nil {
+		return err
+	}
+	upstreamClient, err := dynamic.NewForConfig(upstream)
+	if err != nil {
+		return err
+	}
+	gvrstrs, _, err := discoverGVRs(upstreamDiscovery, sets.NewString(clusterScopedResources...), resources...)
+	if err != nil {
+		return err
+	}
+
+	finalizerName := syncerFinalizerPrefix + clusterID
+	var errs []error
+	for _, gvrstr := range gvrstrs {
+		gvr, _ := schema.ParseResourceArg(gvrstr)
+		list, err := upstreamClient.Resource(*gvr).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for i := range list.Items {
+			unstrob := &list.Items[i]
+			finalizers := withoutFinalizer(unstrob.GetFinalizers(), finalizerName)
+			if len(finalizers) == len(unstrob.GetFinalizers()) {
+				continue
+			}
+			client := upstreamClient.Resource(*gvr).Namespace(unstrob.GetNamespace())
+			if err := patchFinalizers(ctx, client, unstrob, finalizers); err != nil && !k8serrors.IsNotFound(err) {
+				errs = append(errs, err)
+				continue
+			}
+			klog.Infof("Removed finalizer %s from %s %s/%s", finalizerName, gvr.Resource, unstrob.GetNamespace(), unstrob.GetName())
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// deleteDownstream deletes the downstream object with a UID precondition, so that an object
+// recreated with the same name in the meantime is never deleted.
+// Downstream objects which are not owned by the logical cluster are left untouched, and so are the ones
+// created from another upstream object than the one with upstreamUID, if not empty, e.g. when the upstream
+// object was deleted and recreated with the same name.
+// It returns the downstream object if it still exists.
+func (c *Controller) deleteDownstream(ctx context.Context, gvr schema.GroupVersionResource, logicalCluster, namespace, name string, upstreamUID types.UID) (*unstructured.Unstructured, error) {
+	client := c.getClient(gvr, namespace)
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ownedByLogicalCluster(existing, logicalCluster) {
+		klog.Infof("Not deleting downstream object %s %s/%s, which is owned by another logical cluster", gvr.Resource, namespace, name)
+		return nil, nil
+	}
+	// Downstream objects created by syncers which didn't record the upstream UID can't be told apart.
+	if uid, found := existing.GetAnnotations()[UpstreamUIDAnnotation]; found && upstreamUID != "" && types.UID(uid) != upstreamUID {
+		klog.Infof("Not deleting downstream object %s %s/%s, which was created from another upstream object", gvr.Resource, namespace, name)
+		return nil, nil
+	}
+	if existing.GetDeletionTimestamp() != nil {
+		return existing, nil
+	}
+
+	uid := existing.GetUID()
+	if err := client.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	klog.Infof("Deleted downstream object %s %s/%s", gvr.Resource, namespace, name)
+
+	existing, err = client.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.GetUID() != uid {
+		// Deleted, and already recreated by someone else.
+		return nil, nil
+	}
+	return existing, nil
+}
+
+// finalizeDeletion propagates the deletion of an upstream object downstream, and removes the syncer
+// finalizer from the upstream object once the downstream object is confirmed gone.
+func (c *Controller) finalizeDeletion(ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+	if !contains(unstrob.GetFinalizers(), c.finalizerName()) {
+		return nil
+	}
+
+	remaining, err := c.deleteDownstream(ctx, gvr, unstrob.GetClusterName(), c.downstreamNamespace(unstrob.GetClusterName(), namespace), unstrob.GetName(), unstrob.GetUID())
+	if err != nil {
+		klog.Errorf("Deleting downstream resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		return err
+	}
+
+	if remaining != nil {
+		klog.V(2).Infof("Downstream object %s %s/%s is not deleted yet", gvr.Resource, namespace, unstrob.GetName())
+		if deletionTimestamp := remaining.GetDeletionTimestamp(); deletionTimestamp != nil && time.Since(deletionTimestamp.Time) > stuckDeletionTimeout {
+			if err := c.setFromCondition(ctx, gvr, unstrob, DeletionStuckConditionType, "True", "DownstreamObjectNotDeleted",
+				fmt.Sprintf("Object is still present on cluster %s since %s, with finalizers %v", c.clusterID, deletionTimestamp, remaining.GetFinalizers())); err != nil {
+				klog.Errorf("Reporting stuck deletion on upstream resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			}
+		}
+		c.recheckLater(holder{gvr: gvr, obj: unstrob}, deletionRecheckInterval, "downstream object not deleted yet")
+		return nil
+	}
+
+	return c.removeFinalizer(ctx, gvr, unstrob)
+}
@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDeleteDownstreamChecksUpstreamUID(t *testing.T) {
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	downstream := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      "config",
+			"namespace": "ns",
+			"annotations": map[string]interface{}{
+				LogicalClusterAnnotation: "admin",
+				UpstreamUIDAnnotation:    "new-uid",
+			},
+		},
+	}}
+	c := &Controller{toClient: fake.NewSimpleDynamicClient(runtime.NewScheme(), downstream)}
+	ctx := context.Background()
+
+	// The upstream object was deleted and recreated: the downstream object belongs to the new one.
+	if _, err := c.deleteDownstream(ctx, configmaps, "admin", "ns", "config", "old-uid"); err != nil {
+		t.Fatalf("deleteDownstream() = %v", err)
+	}
+	if _, err := c.getClient(configmaps, "ns").Get(ctx, "config", metav1.GetOptions{}); err != nil {
+		t.Errorf("downstream object of the recreated upstream object was deleted: %v", err)
+	}
+
+	if _, err := c.deleteDownstream(ctx, configmaps, "admin", "ns", "config", "new-uid"); err != nil {
+		t.Fatalf("deleteDownstream() = %v", err)
+	}
+	if _, err := c.getClient(configmaps, "ns").Get(ctx, "config", metav1.GetOptions{}); err == nil {
+		t.Errorf("downstream object of the deleted upstream object was not deleted")
+	}
+}
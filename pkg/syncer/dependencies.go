@@ -0,0 +1,360 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// DependencyAnnotation marks the downstream objects synced because they are referenced
+// by the pod template of a synced workload, rather than because they are assigned to the cluster.
+const DependencyAnnotation = "kcp.dev/dependency"
+
+var (
+	configMapsGVR             = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretsGVR                = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	serviceAccountsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	persistentVolumeClaimsGVR = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+
+	// dependencyGVRs are the resource types which pod templates reference.
+	dependencyGVRs = []schema.GroupVersionResource{configMapsGVR, secretsGVR, serviceAccountsGVR, persistentVolumeClaimsGVR}
+)
+
+// dependencyKey identifies an upstream object referenced by the pod template of a synced workload.
+// It is queued when the upstream object changes, or when it is not referenced anymore.
+type dependencyKey struct {
+	gvr            schema.GroupVersionResource
+	logicalCluster string
+	namespace      string
+	name           string
+}
+
+// dependencies tracks the upstream objects referenced by the synced workloads.
+type dependencies struct {
+	// syncFn syncs a referenced upstream object downstream.
+	syncFn    UpsertFunc
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+
+	lock sync.Mutex
+	// referrers are the workloads referencing each dependency.
+	referrers map[dependencyKey]sets.String
+	// references are the dependencies referenced by each workload.
+	references map[string][]dependencyKey
+}
+
+// watchDependencies makes the spec syncer sync the objects referenced by the pod templates of the synced workloads
+// with syncFn, and delete their downstream copy once no synced workload references them anymore.
+// Referenced objects are watched in all the upstream namespaces, as they are not assigned to the cluster.
+func (c *Controller) watchDependencies(syncFn UpsertFunc) {
+	d := &dependencies{
+		syncFn:     syncFn,
+		informers:  map[schema.GroupVersionResource]cache.SharedIndexInformer{},
+		referrers:  map[dependencyKey]sets.String{},
+		references: map[string][]dependencyKey{},
+	}
+	enqueue := func(gvr schema.GroupVersionResource) func(obj interface{}) {
+		return func(obj interface{}) {
+			if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+				obj = tombstone.Obj
+			}
+			meta, isMeta := obj.(metav1.Object)
+			if !isMeta {
+				return
+			}
+			key := dependencyKey{gvr: gvr, logicalCluster: meta.GetClusterName(), namespace: meta.GetNamespace(), name: meta.GetName()}
+			if d.isReferenced(key) {
+				c.queue.Add(key)
+			}
+		}
+	}
+	for _, gvr := range dependencyGVRs {
+		d.informers[gvr] = c.runUpstreamInformer(gvr, cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue(gvr),
+			UpdateFunc: func(oldObj, newObj interface{}) { enqueue(gvr)(newObj) },
+			DeleteFunc: enqueue(gvr),
+		}, c.stopCh)
+
+		klog.Infof("Set up dependency informer for %v", gvr)
+	}
+	c.dependencies = d
+}
+
+func (d *dependencies) isReferenced(key dependencyKey) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.referrers[key].Len() > 0
+}
+
+// setReferences records the dependencies referenced by a workload. It returns the dependencies
+// which were not referenced by the workload before, and the ones not referenced by any workload anymore.
+func (d *dependencies) setReferences(workload string, references []dependencyKey) (added, released []dependencyKey) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	current := map[dependencyKey]bool{}
+	for _, key := range references {
+		current[key] = true
+		if d.referrers[key] == nil {
+			d.referrers[key] = sets.NewString()
+		}
+		if !d.referrers[key].Has(workload) {
+			d.referrers[key].Insert(workload)
+			added = append(added, key)
+		}
+	}
+	for _, key := range d.references[workload] {
+		if current[key] {
+			continue
+		}
+		d.referrers[key].Delete(workload)
+		if d.referrers[key].Len() == 0 {
+			delete(d.referrers, key)
+			released = append(released, key)
+		}
+	}
+	if len(references) == 0 {
+		delete(d.references, workload)
+	} else {
+		d.references[workload] = references
+	}
+	return added, released
+}
+
+// withDependencySync wraps the UpsertFunc and DeleteFunc of the spec syncer, so that the objects
+// referenced by the pod template of a workload are synced before the workload, and deleted
+// after the last workload referencing them.
+func withDependencySync(upsertFn UpsertFunc, deleteFn DeleteFunc) (UpsertFunc, DeleteFunc) {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+			if unstrob.GetDeletionTimestamp() == nil {
+				workload := workloadID(gvr, unstrob.GetClusterName(), namespace, unstrob.GetName())
+				c.updateDependencies(ctx, workload, dependencyReferences(unstrob))
+			}
+			return upsertFn(c, ctx, gvr, namespace, unstrob)
+		}, func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, meta metav1.Object) error {
+			if err := deleteFn(c, ctx, gvr, namespace, meta); err != nil {
+				return err
+			}
+			c.updateDependencies(ctx, workloadID(gvr, meta.GetClusterName(), namespace, meta.GetName()), nil)
+			return nil
+		}
+}
+
+func workloadID(gvr schema.GroupVersionResource, logicalCluster, namespace, name string) string {
+	return fmt.Sprintf("%s|%s|%s/%s", gvr, logicalCluster, namespace, name)
+}
+
+// updateDependencies records the dependencies referenced by a workload, and syncs the newly referenced
+// and the released ones. Dependencies which fail to sync are retried from the queue.
+func (c *Controller) updateDependencies(ctx context.Context, workload string, references []dependencyKey) {
+	added, released := c.dependencies.setReferences(workload, references)
+	for _, key := range append(added, released...) {
+		if err := c.processDependency(ctx, key); err != nil {
+			klog.Errorf("Syncing dependency %s %s/%s: %v", key.gvr.Resource, key.namespace, key.name, err)
+			c.queue.AddRateLimited(key)
+		}
+	}
+}
+
+// syncedAsDependency returns whether the upstream object is synced because a synced workload references it.
+// Nothing is written on such upstream objects, as they are not assigned to the cluster.
+func syncedAsDependency(upstreamObj metav1.Object) bool {
+	_, isDependency := upstreamObj.GetAnnotations()[DependencyAnnotation]
+	return isDependency
+}
+
+// syncKey returns the queue item syncing the upstream object again.
+func syncKey(gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured) interface{} {
+	if syncedAsDependency(upstreamObj) {
+		return dependencyKey{gvr: gvr, logicalCluster: upstreamObj.GetClusterName(), namespace: upstreamObj.GetNamespace(), name: upstreamObj.GetName()}
+	}
+	return holder{gvr: gvr, obj: upstreamObj}
+}
+
+// rebuildReferences records the dependencies referenced by the upstream workloads assigned to the cluster,
+// since references are otherwise only recorded when workloads are synced, and syncs them.
+// It returns false if the controller was stopped before the upstream informers synced.
+func (c *Controller) rebuildReferences(ctx context.Context) bool {
+	for _, informer := range c.dependencies.informers {
+		if !cache.WaitForCacheSync(c.stopCh, informer.HasSynced) {
+			return false
+		}
+	}
+	for _, gvr := range c.syncedGVRs() {
+		informer, found := c.getInformer(gvr)
+		if !found {
+			continue
+		}
+		if !cache.WaitForCacheSync(c.stopCh, informer.HasSynced) {
+			return false
+		}
+		for _, obj := range informer.GetStore().List() {
+			upstream, ok := obj.(*unstructured.Unstructured)
+			if !ok || upstream.GetDeletionTimestamp() != nil || !c.isAssigned(upstream) {
+				continue
+			}
+			workload := workloadID(gvr, upstream.GetClusterName(), upstream.GetNamespace(), upstream.GetName())
+			c.updateDependencies(ctx, workload, dependencyReferences(upstream))
+		}
+	}
+	return true
+}
+
+// processDependency syncs a dependency downstream if it is still referenced, or deletes its downstream copy otherwise.
+func (c *Controller) processDependency(ctx context.Context, key dependencyKey) error {
+	informer := c.dependencies.informers[key.gvr]
+	if !informer.HasSynced() {
+		// The dependency would be considered gone upstream.
+		return fmt.Errorf("informer for %v is not synced yet", key.gvr)
+	}
+	upstream, err := getFromCache(informer, key.logicalCluster, key.namespace, key.name)
+	if err != nil {
+		return err
+	}
+	if upstream != nil && c.assignedToCluster(key.gvr, upstream) {
+		// Synced on its own.
+		return nil
+	}
+	if upstream == nil || upstream.GetDeletionTimestamp() != nil || !c.dependencies.isReferenced(key) {
+		return c.releaseDependency(ctx, key)
+	}
+
+	upstream = upstream.DeepCopy()
+	annotations := upstream.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[DependencyAnnotation] = "true"
+	upstream.SetAnnotations(annotations)
+	return c.dependencies.syncFn(c, ctx, key.gvr, key.namespace, upstream)
+}
+
+// releaseDependency deletes the downstream copy of a dependency, if it was synced as a dependency.
+func (c *Controller) releaseDependency(ctx context.Context, key dependencyKey) error {
+	downstreamNamespace := c.downstreamNamespace(key.logicalCluster, key.namespace)
+	existing, err := c.getClient(key.gvr, downstreamNamespace).Get(ctx, key.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, isDependency := existing.GetAnnotations()[DependencyAnnotation]; !isDependency {
+		return nil
+	}
+	_, err = c.deleteDownstream(ctx, key.gvr, key.logicalCluster, downstreamNamespace, key.name, "")
+	return err
+}
+
+// assignedToCluster returns whether the upstream object is synced on its own, because its resource type
+// is synced and it is assigned to the cluster.
+func (c *Controller) assignedToCluster(gvr schema.GroupVersionResource, upstream *unstructured.Unstructured) bool {
+	if _, synced := c.getInformer(gvr); !synced {
+		return false
+	}
+	return c.isAssigned(upstream)
+}
+
+// podSpec returns the pod spec of a pod, or the pod template spec of a workload, if any.
+func podSpec(unstrob *unstructured.Unstructured) (map[string]interface{}, bool) {
+	for _, path := range [][]string{
+		{"spec", "template", "spec"},
+		{"spec", "jobTemplate", "spec", "template", "spec"},
+	} {
+		if spec, found, _ := unstructured.NestedMap(unstrob.Object, path...); found {
+			return spec, true
+		}
+	}
+	if unstrob.GetKind() == "Pod" {
+		spec, found, _ := unstructured.NestedMap(unstrob.Object, "spec")
+		return spec, found
+	}
+	return nil, false
+}
+
+// dependencyReferences returns the ConfigMaps, Secrets, ServiceAccount and PersistentVolumeClaims
+// referenced by the pod spec of a workload, sorted by resource and name.
+// The default ServiceAccount is left out, as it exists in every downstream namespace.
+func dependencyReferences(unstrob *unstructured.Unstructured) []dependencyKey {
+	spec, found := podSpec(unstrob)
+	if !found {
+		return nil
+	}
+
+	keys := map[dependencyKey]bool{}
+	add := func(gvr schema.GroupVersionResource, name string) {
+		if name != "" {
+			keys[dependencyKey{gvr: gvr, logicalCluster: unstrob.GetClusterName(), namespace: unstrob.GetNamespace(), name: name}] = true
+		}
+	}
+
+	serviceAccount := nestedString(spec, "serviceAccountName")
+	if serviceAccount == "" {
+		serviceAccount = nestedString(spec, "serviceAccount")
+	}
+	if serviceAccount != "default" {
+		add(serviceAccountsGVR, serviceAccount)
+	}
+	for _, secret := range nestedMaps(spec, "imagePullSecrets") {
+		add(secretsGVR, nestedString(secret, "name"))
+	}
+	for _, volume := range nestedMaps(spec, "volumes") {
+		add(configMapsGVR, nestedString(volume, "configMap", "name"))
+		add(secretsGVR, nestedString(volume, "secret", "secretName"))
+		add(persistentVolumeClaimsGVR, nestedString(volume, "persistentVolumeClaim", "claimName"))
+		for _, source := range nestedMaps(volume, "projected", "sources") {
+			add(configMapsGVR, nestedString(source, "configMap", "name"))
+			add(secretsGVR, nestedString(source, "secret", "name"))
+		}
+	}
+	for _, containers := range []string{"initContainers", "containers"} {
+		for _, container := range nestedMaps(spec, containers) {
+			for _, env := range nestedMaps(container, "env") {
+				add(configMapsGVR, nestedString(env, "valueFrom", "configMapKeyRef", "name"))
+				add(secretsGVR, nestedString(env, "valueFrom", "secretKeyRef", "name"))
+			}
+			for _, envFrom := range nestedMaps(container, "envFrom") {
+				add(configMapsGVR, nestedString(envFrom, "configMapRef", "name"))
+				add(secretsGVR, nestedString(envFrom, "secretRef", "name"))
+			}
+		}
+	}
+
+	var references []dependencyKey
+	for key := range keys {
+		references = append(references, key)
+	}
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].gvr.Resource != references[j].gvr.Resource {
+			return references[i].gvr.Resource < references[j].gvr.Resource
+		}
+		return references[i].name < references[j].name
+	})
+	return references
+}
+
+func nestedString(obj map[string]interface{}, fields ...string) string {
+	value, _, _ := unstructured.NestedString(obj, fields...)
+	return value
+}
+
+// nestedMaps returns the maps of the slice at the given path.
+func nestedMaps(obj map[string]interface{}, fields ...string) []map[string]interface{} {
+	slice, _, _ := unstructured.NestedSlice(obj, fields...)
+	var maps []map[string]interface{}
+	for _, item := range slice {
+		if m, isMap := item.(map[string]interface{}); isMap {
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestDependencyReferences(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "ns", "clusterName": "admin"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"serviceAccountName": "web",
+					"imagePullSecrets":   []interface{}{map[string]interface{}{"name": "registry"}},
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "web-config"}},
+						map[string]interface{}{"name": "data", "persistentVolumeClaim": map[string]interface{}{"claimName": "web-data"}},
+					},
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "web",
+							"env": []interface{}{
+								map[string]interface{}{"name": "TOKEN", "valueFrom": map[string]interface{}{
+									"secretKeyRef": map[string]interface{}{"name": "web-token", "key": "token"},
+								}},
+							},
+							"envFrom": []interface{}{
+								map[string]interface{}{"configMapRef": map[string]interface{}{"name": "web-config"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+
+	key := func(resource, name string) dependencyKey {
+		for _, gvr := range dependencyGVRs {
+			if gvr.Resource == resource {
+				return dependencyKey{gvr: gvr, logicalCluster: "admin", namespace: "ns", name: name}
+			}
+		}
+		t.Fatalf("unknown dependency resource %q", resource)
+		return dependencyKey{}
+	}
+	want := []dependencyKey{
+		key("configmaps", "web-config"),
+		key("persistentvolumeclaims", "web-data"),
+		key("secrets", "registry"),
+		key("secrets", "web-token"),
+		key("serviceaccounts", "web"),
+	}
+	if got := dependencyReferences(deployment); !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencyReferences() = %v, want %v", got, want)
+	}
+
+	d := &dependencies{referrers: map[dependencyKey]sets.String{}, references: map[string][]dependencyKey{}}
+	if added, _ := d.setReferences("a", want[:2]); len(added) != 2 {
+		t.Errorf("setReferences() added %v, want 2 dependencies", added)
+	}
+	if added, _ := d.setReferences("b", want[:1]); len(added) != 1 {
+		t.Errorf("setReferences() added %v, want 1 dependency", added)
+	}
+	// The ConfigMap is still referenced by b.
+	if _, released := d.setReferences("a", nil); !reflect.DeepEqual(released, want[1:2]) {
+		t.Errorf("setReferences() released %v, want %v", released, want[1:2])
+	}
+	if _, released := d.setReferences("b", nil); !reflect.DeepEqual(released, want[:1]) {
+		t.Errorf("setReferences() released %v, want %v", released, want[:1])
+	}
+}
@@ -0,0 +1,194 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// DriftPolicyRevert makes the spec syncer sync the upstream object again when its downstream object drifted.
+	// With server-side apply, fields taken over by other field managers are reported as apply conflicts instead.
+	DriftPolicyRevert = "revert"
+	// DriftPolicyReport makes the spec syncer only report the drift of downstream objects on their upstream object.
+	DriftPolicyReport = "report"
+
+	// driftAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+	// which reports the drift of the downstream object on the upstream object.
+	driftAnnotationPrefix = "kcp.dev/drift."
+)
+
+// validateDriftPolicy returns an error if the drift policy is unknown.
+func validateDriftPolicy(driftPolicy string) error {
+	switch driftPolicy {
+	case "", DriftPolicyRevert, DriftPolicyReport:
+		return nil
+	default:
+		return fmt.Errorf("unknown drift policy %q", driftPolicy)
+	}
+}
+
+// driftKey is queued when a downstream object assigned to the cluster changes, to check whether
+// it drifted from its upstream object.
+type driftKey struct {
+	gvr            schema.GroupVersionResource
+	logicalCluster string
+	namespace      string
+	name           string
+}
+
+// downstreamHandlers returns the handlers of the downstream informers of the spec syncer,
+// which queue a driftKey for every changed or deleted downstream object.
+func downstreamHandlers(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+			obj = tombstone.Obj
+		}
+		unstrob, isUnstructured := obj.(*unstructured.Unstructured)
+		if !isUnstructured {
+			return
+		}
+		logicalCluster, found := unstrob.GetAnnotations()[LogicalClusterAnnotation]
+		if !found {
+			// Not synced by a syncer which records the logical cluster.
+			return
+		}
+		c.queue.Add(driftKey{
+			gvr:            gvr,
+			logicalCluster: logicalCluster,
+			namespace:      upstreamNamespace(unstrob),
+			name:           unstrob.GetName(),
+		})
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if downstreamChanged(oldObj, newObj) {
+				enqueue(newObj)
+			}
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// downstreamChanged returns whether a downstream object changed in a way which may make it drift
+// from its upstream object, ignoring status updates and resyncs. Objects without a generation,
+// e.g. ConfigMaps, are compared apart from their status.
+func downstreamChanged(oldObj, newObj interface{}) bool {
+	oldMeta, isOldMeta := oldObj.(metav1.Object)
+	newMeta, isNewMeta := newObj.(metav1.Object)
+	if !isOldMeta || !isNewMeta {
+		return true
+	}
+	if newMeta.GetGeneration() == 0 {
+		return !deepEqualApartFromStatus(oldObj, newObj)
+	}
+	return oldMeta.GetGeneration() != newMeta.GetGeneration() ||
+		!equality.Semantic.DeepEqual(oldMeta.GetLabels(), newMeta.GetLabels()) ||
+		!equality.Semantic.DeepEqual(oldMeta.GetAnnotations(), newMeta.GetAnnotations())
+}
+
+// processDrift compares a downstream object with its upstream object, and either syncs the upstream
+// object again or reports the drift on it, according to the drift policy.
+func (c *Controller) processDrift(key driftKey) error {
+	informer, found := c.getInformer(key.gvr)
+	if !found {
+		return nil
+	}
+	upstream, err := getFromCache(informer, key.logicalCluster, key.namespace, key.name)
+	if err != nil {
+		return err
+	}
+	if upstream == nil || upstream.GetDeletionTimestamp() != nil || !c.isAssigned(upstream) {
+		// The downstream object is being deleted along with the upstream object, or its assignment.
+		return nil
+	}
+	downstreamInformer, found := c.getDownstreamInformer(key.gvr)
+	if !found {
+		return nil
+	}
+	downstreamNamespace := c.downstreamNamespace(key.logicalCluster, key.namespace)
+	downstream, err := getFromCache(downstreamInformer, "", downstreamNamespace, key.name)
+	if err != nil {
+		return err
+	}
+
+	var drift string
+	if downstream == nil {
+		drift = fmt.Sprintf("%s %s/%s was deleted on cluster %s", key.gvr.Resource, downstreamNamespace, key.name, c.clusterID)
+	} else {
+		if !ownedByLogicalCluster(downstream, key.logicalCluster) {
+			return nil
+		}
+		desired, err := c.transformForDownstream(key.gvr, upstream)
+		if err != nil {
+			return err
+		}
+		if field := driftedField(desired, downstream); field != "" {
+			drift = fmt.Sprintf("%s %s/%s was changed on cluster %s: %s differs", key.gvr.Resource, downstreamNamespace, key.name, c.clusterID, field)
+		}
+	}
+
+	if drift != "" && c.driftPolicy != DriftPolicyReport {
+		klog.Infof("Reverting drift: %s", drift)
+		c.queue.Add(holder{gvr: key.gvr, obj: upstream})
+		return nil
+	}
+	if drift != "" {
+		klog.Infof("Detected drift: %s", drift)
+	}
+	return c.setFromAnnotation(context.TODO(), key.gvr, upstream, driftAnnotationPrefix+c.clusterID, drift)
+}
+
+// driftedField returns the path of the first field of the desired downstream object, outside of
+// metadata and status, whose value differs in the actual downstream object, or "" if there is none.
+// Fields which are only set in the actual object, e.g. defaulted fields, are not considered drift.
+func driftedField(desired, actual *unstructured.Unstructured) string {
+	for key, value := range desired.Object {
+		if key == "metadata" || key == "status" {
+			continue
+		}
+		if path := driftedPath(key, value, actual.Object[key]); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+func driftedPath(path string, desired, actual interface{}) string {
+	switch typed := desired.(type) {
+	case map[string]interface{}:
+		actualMap, isMap := actual.(map[string]interface{})
+		if !isMap {
+			return path
+		}
+		for key, value := range typed {
+			if p := driftedPath(path+"."+key, value, actualMap[key]); p != "" {
+				return p
+			}
+		}
+		return ""
+	case []interface{}:
+		actualSlice, isSlice := actual.([]interface{})
+		if !isSlice || len(actualSlice) != len(typed) {
+			return path
+		}
+		for i, value := range typed {
+			if p := driftedPath(fmt.Sprintf("%s[%d]", path, i), value, actualSlice[i]); p != "" {
+				return p
+			}
+		}
+		return ""
+	default:
+		if !equality.Semantic.DeepEqual(desired, actual) {
+			return path
+		}
+		return ""
+	}
+}
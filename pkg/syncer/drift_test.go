@@ -0,0 +1,81 @@
+package syncer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDriftedField(t *testing.T) {
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "c", "image": "nginx"},
+					},
+				},
+			},
+		},
+	}}
+
+	for _, tc := range []struct {
+		name   string
+		actual map[string]interface{}
+		want   string
+	}{{
+		name: "defaulted fields",
+		actual: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "foo", "resourceVersion": "42"},
+			"spec": map[string]interface{}{
+				"replicas":             int64(2),
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "c", "image": "nginx", "imagePullPolicy": "Always"},
+						},
+					},
+				},
+			},
+			"status": map[string]interface{}{"replicas": int64(1)},
+		},
+	}, {
+		name: "changed field",
+		actual: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"replicas": int64(2),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "c", "image": "httpd"},
+						},
+					},
+				},
+			},
+		},
+		want: "spec.template.spec.containers[0].image",
+	}, {
+		name: "removed field",
+		actual: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "c", "image": "nginx"},
+						},
+					},
+				},
+			},
+		},
+		want: "spec.replicas",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := driftedField(desired, &unstructured.Unstructured{Object: tc.actual}); got != tc.want {
+				t.Errorf("driftedField() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+// DryRunChange is a change which the syncer would have made, reported in dry-run mode.
+type DryRunChange struct {
+	// Action is create, update, update-status, patch, delete or delete-collection.
+	Action string `json:"action"`
+	// Side is upstream or downstream.
+	Side      string `json:"side"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Diff is the field-level diff against the live object, if any.
+	Diff []FieldDiff `json:"diff,omitempty"`
+}
+
+// FieldDiff is the change of the value of a field. Old is unset for an added field, and New for a removed field.
+type FieldDiff struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// dryRunOutputLock serializes the writes of the spec and status syncers to the dry-run output.
+var dryRunOutputLock sync.Mutex
+
+// dryRunClient is a dynamic client which sends every write with server-side dry-run,
+// and reports the change it would have made instead.
+type dryRunClient struct {
+	client dynamic.Interface
+	side   string
+	out    io.Writer
+}
+
+// newDryRunClient returns a dry-run client, which writes the changes as JSON lines to out, or logs them if out is nil.
+func newDryRunClient(client dynamic.Interface, side string, out io.Writer) dynamic.Interface {
+	return &dryRunClient{client: client, side: side, out: out}
+}
+
+func (c *dryRunClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	nri := c.client.Resource(gvr)
+	return &dryRunResource{ResourceInterface: nri, nri: nri, gvr: gvr, client: c}
+}
+
+func (c *dryRunClient) report(change DryRunChange) {
+	change.Side = c.side
+	data, err := json.Marshal(change)
+	if err != nil {
+		klog.Errorf("Encoding dry-run change: %v", err)
+		return
+	}
+	if c.out == nil {
+		klog.Infof("Dry run: %s", data)
+		return
+	}
+
+	dryRunOutputLock.Lock()
+	defer dryRunOutputLock.Unlock()
+	if _, err := c.out.Write(append(data, '\n')); err != nil {
+		klog.Errorf("Writing dry-run change: %v", err)
+	}
+}
+
+type dryRunResource struct {
+	dynamic.ResourceInterface
+	nri       dynamic.NamespaceableResourceInterface
+	gvr       schema.GroupVersionResource
+	namespace string
+	client    *dryRunClient
+}
+
+func (r *dryRunResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &dryRunResource{ResourceInterface: r.nri.Namespace(namespace), nri: r.nri, gvr: r.gvr, namespace: namespace, client: r.client}
+}
+
+func (r *dryRunResource) report(action, name string, live, result *unstructured.Unstructured) {
+	var liveObj, resultObj map[string]interface{}
+	if live != nil {
+		liveObj = live.Object
+	}
+	if result != nil {
+		resultObj = result.Object
+	}
+	diff := diffObjects(liveObj, resultObj)
+	if live != nil && result != nil && len(diff) == 0 {
+		return
+	}
+	r.client.report(DryRunChange{
+		Action:    action,
+		Resource:  r.gvr.GroupResource().String(),
+		Namespace: r.namespace,
+		Name:      name,
+		Diff:      diff,
+	})
+}
+
+// live returns the live object, or nil if it doesn't exist.
+func (r *dryRunResource) live(ctx context.Context, name string, subresources ...string) (*unstructured.Unstructured, error) {
+	live, err := r.ResourceInterface.Get(ctx, name, metav1.GetOptions{}, subresources...)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return live, err
+}
+
+func (r *dryRunResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	options.DryRun = []string{metav1.DryRunAll}
+	result, err := r.ResourceInterface.Create(ctx, obj, options, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	r.report("create", obj.GetName(), nil, result)
+	return result, nil
+}
+
+func (r *dryRunResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	live, err := r.live(ctx, obj.GetName(), subresources...)
+	if err != nil {
+		return nil, err
+	}
+	options.DryRun = []string{metav1.DryRunAll}
+	result, err := r.ResourceInterface.Update(ctx, obj, options, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	r.report("update", obj.GetName(), live, result)
+	return result, nil
+}
+
+func (r *dryRunResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	live, err := r.live(ctx, obj.GetName())
+	if err != nil {
+		return nil, err
+	}
+	options.DryRun = []string{metav1.DryRunAll}
+	result, err := r.ResourceInterface.UpdateStatus(ctx, obj, options)
+	if err != nil {
+		return nil, err
+	}
+	r.report("update-status", obj.GetName(), live, result)
+	return result, nil
+}
+
+func (r *dryRunResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	live, err := r.live(ctx, name, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	options.DryRun = []string{metav1.DryRunAll}
+	result, err := r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	action := "patch"
+	if live == nil {
+		action = "create"
+	}
+	r.report(action, name, live, result)
+	return result, nil
+}
+
+func (r *dryRunResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	live, err := r.live(ctx, name, subresources...)
+	if err != nil {
+		return err
+	}
+	options.DryRun = []string{metav1.DryRunAll}
+	if err := r.ResourceInterface.Delete(ctx, name, options, subresources...); err != nil {
+		return err
+	}
+	r.report("delete", name, live, nil)
+	return nil
+}
+
+func (r *dryRunResource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	options.DryRun = []string{metav1.DryRunAll}
+	if err := r.ResourceInterface.DeleteCollection(ctx, options, listOptions); err != nil {
+		return err
+	}
+	r.client.report(DryRunChange{
+		Action:    "delete-collection",
+		Resource:  r.gvr.GroupResource().String(),
+		Namespace: r.namespace,
+	})
+	return nil
+}
+
+// ignoredDiffFields are the metadata fields set by the API server, which are left out of the diffs.
+var ignoredDiffFields = map[string]bool{
+	"metadata.resourceVersion":   true,
+	"metadata.managedFields":     true,
+	"metadata.uid":               true,
+	"metadata.creationTimestamp": true,
+	"metadata.generation":        true,
+	"metadata.selfLink":          true,
+}
+
+// diffObjects returns the field-level diff between the old and new objects, sorted by path.
+func diffObjects(oldObj, newObj map[string]interface{}) []FieldDiff {
+	var diff []FieldDiff
+	diffFields("", oldObj, newObj, &diff)
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Path < diff[j].Path })
+	return diff
+}
+
+func diffFields(path string, oldValue, newValue interface{}, diff *[]FieldDiff) {
+	if ignoredDiffFields[path] {
+		return
+	}
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if (oldIsMap || oldValue == nil) && (newIsMap || newValue == nil) && (oldIsMap || newIsMap) {
+		for key, value := range oldMap {
+			diffFields(joinPath(path, key), value, newMap[key], diff)
+		}
+		for key, value := range newMap {
+			if _, found := oldMap[key]; !found {
+				diffFields(joinPath(path, key), nil, value, diff)
+			}
+		}
+		return
+	}
+	if !equality.Semantic.DeepEqual(oldValue, newValue) {
+		*diff = append(*diff, FieldDiff{Path: path, Old: oldValue, New: newValue})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", path, key)
+}
@@ -0,0 +1,43 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffObjects(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            "foo",
+			"resourceVersion": "1",
+			"labels":          map[string]interface{}{"a": "b"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"paused":   true,
+		},
+	}
+	result := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            "foo",
+			"resourceVersion": "2",
+			"labels":          map[string]interface{}{"a": "b", "c": "d"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+		},
+	}
+
+	want := []FieldDiff{
+		{Path: "metadata.labels.c", New: "d"},
+		{Path: "spec.paused", Old: true},
+		{Path: "spec.replicas", Old: int64(1), New: int64(3)},
+	}
+	if got := diffObjects(live, result); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffObjects() = %v, want %v", got, want)
+	}
+
+	if got := diffObjects(live, live); len(got) != 0 {
+		t.Errorf("diffObjects() of identical objects = %v, want no diff", got)
+	}
+}
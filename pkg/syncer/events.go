@@ -0,0 +1,220 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// SourceClusterAnnotation records, on the Events republished upstream, the cluster they come from.
+const SourceClusterAnnotation = "kcp.dev/source-cluster"
+
+// maxOwnerDepth bounds the owner references followed from the object an Event is about
+// to find a synced object, e.g. Pod -> ReplicaSet -> Deployment.
+const maxOwnerDepth = 4
+
+// eventHandlers returns the handlers of the downstream Events informer of the status syncer,
+// which only queue the Events about synced objects, or about objects in the namespace of a synced object,
+// which may be owned by it, e.g. the Pods of a synced Deployment.
+func eventHandlers(c *Controller) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		event, isUnstructured := obj.(*unstructured.Unstructured)
+		if !isUnstructured {
+			return
+		}
+		reference, namespace := involvedObject(event)
+		if _, synced := c.getSyncedObject(reference, namespace); synced != nil || c.hasSyncedObjects(namespace) {
+			c.AddToQueue(eventsGVR, obj)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) { enqueue(newObj) },
+	}
+}
+
+// withEventSync wraps the UpsertFunc of the status syncer, so that downstream Events
+// about synced objects are republished upstream.
+func withEventSync(upsertFn UpsertFunc) UpsertFunc {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+		if gvr == eventsGVR {
+			return c.syncEventUpstream(ctx, unstrob)
+		}
+		return upsertFn(c, ctx, gvr, namespace, unstrob)
+	}
+}
+
+// involvedObject returns a reference to the object the Event is about, and its namespace.
+func involvedObject(event *unstructured.Unstructured) (metav1.OwnerReference, string) {
+	apiVersion, _, _ := unstructured.NestedString(event.Object, "involvedObject", "apiVersion")
+	kind, _, _ := unstructured.NestedString(event.Object, "involvedObject", "kind")
+	namespace, _, _ := unstructured.NestedString(event.Object, "involvedObject", "namespace")
+	name, _, _ := unstructured.NestedString(event.Object, "involvedObject", "name")
+	uid, _, _ := unstructured.NestedString(event.Object, "involvedObject", "uid")
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(uid)}, namespace
+}
+
+// getSyncedObject returns the synced downstream object with the given reference from the informer cache,
+// along with its GVR, or nil if it is not a synced object.
+func (c *Controller) getSyncedObject(reference metav1.OwnerReference, namespace string) (schema.GroupVersionResource, *unstructured.Unstructured) {
+	gv, err := schema.ParseGroupVersion(reference.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, nil
+	}
+
+	for _, gvr := range c.syncedGVRs() {
+		if gvr.Group != gv.Group {
+			continue
+		}
+		informer, found := c.getInformer(gvr)
+		if !found {
+			continue
+		}
+		obj, err := getFromCache(informer, "", namespace, reference.Name)
+		if err != nil || obj == nil || obj.GetKind() != reference.Kind {
+			continue
+		}
+		if reference.UID != "" && obj.GetUID() != reference.UID {
+			continue
+		}
+		return gvr, obj
+	}
+	return schema.GroupVersionResource{}, nil
+}
+
+// hasSyncedObjects returns whether the downstream namespace holds synced objects.
+func (c *Controller) hasSyncedObjects(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	for _, gvr := range c.syncedGVRs() {
+		informer, found := c.getInformer(gvr)
+		if !found {
+			continue
+		}
+		if objs, err := informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace); err == nil && len(objs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// involvedSyncedObject returns the synced downstream object which the Event is about, along with its GVR:
+// the involved object itself, or the first synced object found by following the controller owner references
+// of the involved object, e.g. the Deployment of a Pod. Owners which are not synced are got from the downstream cluster.
+// It returns nil if the Event is not about a synced object.
+func (c *Controller) involvedSyncedObject(ctx context.Context, event *unstructured.Unstructured) (schema.GroupVersionResource, *unstructured.Unstructured, error) {
+	reference, namespace := involvedObject(event)
+	for depth := 0; depth <= maxOwnerDepth; depth++ {
+		if gvr, synced := c.getSyncedObject(reference, namespace); synced != nil {
+			return gvr, synced, nil
+		}
+		if depth == maxOwnerDepth {
+			break
+		}
+
+		gvr, objNamespace, found := c.ownerGVR(reference, namespace)
+		if !found {
+			break
+		}
+		obj, err := c.getFromClient(gvr, objNamespace).Get(ctx, reference.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return schema.GroupVersionResource{}, nil, err
+		}
+		if reference.UID != "" && obj.GetUID() != reference.UID {
+			break
+		}
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil {
+			break
+		}
+		reference, namespace = *owner, objNamespace
+	}
+	return schema.GroupVersionResource{}, nil, nil
+}
+
+// syncEventUpstream republishes a downstream Event about a synced object, or an object it owns, in the logical cluster
+// of the synced object, against its upstream object. The upstream Event is named after the downstream Event and the cluster,
+// so that the updates of the downstream Event update the same upstream Event.
+func (c *Controller) syncEventUpstream(ctx context.Context, event *unstructured.Unstructured) error {
+	gvr, involved, err := c.involvedSyncedObject(ctx, event)
+	if err != nil || involved == nil {
+		return err
+	}
+	logicalCluster := involved.GetAnnotations()[LogicalClusterAnnotation]
+	namespace := upstreamNamespace(involved)
+
+	upstreamObj, err := c.getClient(gvr, namespace).Get(ctx, involved.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if logicalCluster != "" && upstreamObj.GetClusterName() != logicalCluster {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s.%s", event.GetName(), c.clusterID)
+	eventNamespace := namespace
+	if eventNamespace == "" {
+		eventNamespace = metav1.NamespaceDefault
+	}
+
+	upstreamEvent := event.DeepCopy()
+	upstreamEvent.SetName(name)
+	upstreamEvent.SetNamespace(eventNamespace)
+	upstreamEvent.SetClusterName(upstreamObj.GetClusterName())
+	upstreamEvent.SetAnnotations(map[string]string{SourceClusterAnnotation: c.clusterID})
+	upstreamEvent.SetLabels(nil)
+	upstreamEvent.SetUID("")
+	upstreamEvent.SetResourceVersion("")
+	upstreamEvent.SetManagedFields(nil)
+	upstreamEvent.SetCreationTimestamp(metav1.Time{})
+	upstreamEvent.SetOwnerReferences(nil)
+	if err := unstructured.SetNestedMap(upstreamEvent.Object, map[string]interface{}{
+		"apiVersion":      upstreamObj.GetAPIVersion(),
+		"kind":            upstreamObj.GetKind(),
+		"namespace":       upstreamObj.GetNamespace(),
+		"name":            upstreamObj.GetName(),
+		"uid":             string(upstreamObj.GetUID()),
+		"resourceVersion": upstreamObj.GetResourceVersion(),
+	}, "involvedObject"); err != nil {
+		return err
+	}
+
+	client := c.getClient(eventsGVR, eventNamespace)
+	if _, err := client.Create(ctx, upstreamEvent, metav1.CreateOptions{}); err == nil || !k8serrors.IsAlreadyExists(err) {
+		if err != nil {
+			klog.Errorf("Creating upstream event %s/%s: %v", eventNamespace, name, err)
+		}
+		return err
+	}
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(existing.Object["count"], upstreamEvent.Object["count"]) &&
+		equality.Semantic.DeepEqual(existing.Object["lastTimestamp"], upstreamEvent.Object["lastTimestamp"]) &&
+		equality.Semantic.DeepEqual(existing.Object["series"], upstreamEvent.Object["series"]) {
+		return nil
+	}
+	upstreamEvent.SetResourceVersion(existing.GetResourceVersion())
+	if _, err := client.Update(ctx, upstreamEvent, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Updating upstream event %s/%s: %v", eventNamespace, name, err)
+		return err
+	}
+	return nil
+}
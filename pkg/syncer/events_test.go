@@ -0,0 +1,87 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/restmapper"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInvolvedSyncedObject(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	object := func(apiVersion, kind, name string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace("ns")
+		obj.SetName(name)
+		obj.SetUID(types.UID(name + "-uid"))
+		if owner != nil {
+			controller := true
+			obj.SetOwnerReferences([]metav1.OwnerReference{{
+				APIVersion: owner.GetAPIVersion(),
+				Kind:       owner.GetKind(),
+				Name:       owner.GetName(),
+				UID:        owner.GetUID(),
+				Controller: &controller,
+			}})
+		}
+		return obj
+	}
+	deployment := object("apps/v1", "Deployment", "web", nil)
+	replicaSet := object("apps/v1", "ReplicaSet", "web-5d4f", deployment)
+	pod := object("v1", "Pod", "web-5d4f-x7k2p", replicaSet)
+
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod", Namespaced: true}}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment", Namespaced: true},
+			{Name: "replicasets", Kind: "ReplicaSet", Namespaced: true},
+		}},
+	}}}
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := informer.GetIndexer().Add(deployment); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		fromClient: fake.NewSimpleDynamicClient(runtime.NewScheme(), replicaSet, pod),
+		informers:  map[schema.GroupVersionResource]*resourceInformer{deployments: {informer: informer}},
+		restMapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discovery)),
+	}
+
+	event := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata":   map[string]interface{}{"name": "web-5d4f-x7k2p.16b", "namespace": "ns"},
+		"involvedObject": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"namespace":  "ns",
+			"name":       pod.GetName(),
+			"uid":        string(pod.GetUID()),
+		},
+		"reason": "BackOff",
+	}}
+	gvr, involved, err := c.involvedSyncedObject(context.Background(), event)
+	if err != nil {
+		t.Fatalf("involvedSyncedObject() = %v", err)
+	}
+	if gvr != deployments || involved == nil || involved.GetName() != deployment.GetName() {
+		t.Errorf("involvedSyncedObject() = %v %v, want deployment %s", gvr, involved, deployment.GetName())
+	}
+
+	if !c.hasSyncedObjects("ns") || c.hasSyncedObjects("other") {
+		t.Errorf("hasSyncedObjects() doesn't match the namespaces of the synced objects")
+	}
+}
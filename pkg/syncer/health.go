@@ -0,0 +1,74 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+const (
+	// stuckQueueTimeout is how long the queue of a controller can be non-empty
+	// without any item being processed, before the controller is reported as unhealthy.
+	stuckQueueTimeout = 5 * time.Minute
+	// connectivityTimeout is how long the readiness check waits for an API server to answer.
+	connectivityTimeout = 5 * time.Second
+)
+
+// HealthChecks returns the liveness checks of the syncer, served on /healthz.
+func (s *Syncer) HealthChecks() []healthz.HealthChecker {
+	return []healthz.HealthChecker{
+		healthz.PingHealthz,
+		healthz.NamedCheck("spec-queue", s.specSyncer.checkQueue),
+		healthz.NamedCheck("status-queue", s.statusSyncer.checkQueue),
+	}
+}
+
+// ReadyChecks returns the readiness checks of the syncer, served on /readyz.
+func (s *Syncer) ReadyChecks() []healthz.HealthChecker {
+	return append(s.HealthChecks(),
+		healthz.NamedCheck("upstream", s.specSyncer.checkFromConnectivity),
+		healthz.NamedCheck("downstream", s.statusSyncer.checkFromConnectivity),
+		healthz.NamedCheck("spec-informers", s.specSyncer.checkInformersSynced),
+		healthz.NamedCheck("status-informers", s.statusSyncer.checkInformersSynced),
+	)
+}
+
+// checkQueue returns an error if the queue of the controller is shut down,
+// or if no item was processed for a while although the queue is not empty.
+func (c *Controller) checkQueue(_ *http.Request) error {
+	if c.queue.ShuttingDown() {
+		return fmt.Errorf("queue is shut down")
+	}
+	if c.queue.Len() == 0 {
+		return nil
+	}
+	if lastProcessed := time.Unix(0, atomic.LoadInt64(&c.lastProcessed)); time.Since(lastProcessed) > stuckQueueTimeout {
+		return fmt.Errorf("%d queued items, and no item processed since %s", c.queue.Len(), lastProcessed)
+	}
+	return nil
+}
+
+// checkFromConnectivity returns an error if the API server on the "from" side doesn't answer.
+func (c *Controller) checkFromConnectivity(r *http.Request) error {
+	return c.fromDiscovery.RESTClient().Get().AbsPath("/version").Timeout(connectivityTimeout).Do(r.Context()).Error()
+}
+
+// checkInformersSynced returns an error if some informers of the controller have not synced yet.
+func (c *Controller) checkInformersSynced(_ *http.Request) error {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	var notSynced []string
+	for gvr, ri := range c.informers {
+		if !ri.informer.HasSynced() || (ri.downstream != nil && !ri.downstream.HasSynced()) {
+			notSynced = append(notSynced, gvr.String())
+		}
+	}
+	if len(notSynced) > 0 {
+		return fmt.Errorf("informers not synced yet: %v", notSynced)
+	}
+	return nil
+}
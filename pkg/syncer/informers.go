@@ -0,0 +1,273 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// resourceInformer is an upstream informer for a single GVR, which can be
+// stopped independently of the informers for the other synced GVRs.
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+
+	// downstream is the informer for the downstream objects of the GVR assigned to the cluster,
+	// if the controller watches downstream objects.
+	downstream cache.SharedIndexInformer
+
+	// pinned informers are not stopped when their resource type is not discovered anymore.
+	pinned bool
+}
+
+// getInformer returns the upstream informer for the GVR, if it is currently synced.
+func (c *Controller) getInformer(gvr schema.GroupVersionResource) (cache.SharedIndexInformer, bool) {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	ri, found := c.informers[gvr]
+	if !found {
+		return nil, false
+	}
+	return ri.informer, true
+}
+
+// getDownstreamInformer returns the downstream informer for the GVR, if it is currently synced
+// and the controller watches downstream objects.
+func (c *Controller) getDownstreamInformer(gvr schema.GroupVersionResource) (cache.SharedIndexInformer, bool) {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	ri, found := c.informers[gvr]
+	if !found || ri.downstream == nil {
+		return nil, false
+	}
+	return ri.downstream, true
+}
+
+// getFromCache returns the object with the given logical cluster, namespace and name from the informer cache,
+// or nil if it doesn't exist.
+func getFromCache(informer cache.SharedIndexInformer, logicalCluster, namespace, name string) (*unstructured.Unstructured, error) {
+	key := &unstructured.Unstructured{}
+	key.SetName(name)
+	key.SetNamespace(namespace)
+	key.SetClusterName(logicalCluster)
+	obj, exists, err := informer.GetIndexer().Get(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return obj.(*unstructured.Unstructured), nil
+}
+
+// getDownstream returns the downstream object with the given namespace and name from the downstream informer
+// cache, or from the downstream cluster if its resource type is not informed, or nil if it doesn't exist.
+func (c *Controller) getDownstream(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if informer, found := c.getDownstreamInformer(gvr); found && informer.HasSynced() {
+		return getFromCache(informer, "", namespace, name)
+	}
+	return c.getLiveDownstream(ctx, gvr, namespace, name)
+}
+
+// getAnyDownstream returns the downstream object with the given namespace and name from the downstream informer
+// cache, or from the downstream cluster if it is not cached, or nil if it doesn't exist. Unlike getDownstream,
+// it finds the downstream objects which are not labeled for the cluster, e.g. created outside of kcp.
+func (c *Controller) getAnyDownstream(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if informer, found := c.getDownstreamInformer(gvr); found && informer.HasSynced() {
+		obj, err := getFromCache(informer, "", namespace, name)
+		if err != nil || obj != nil {
+			return obj, err
+		}
+	}
+	return c.getLiveDownstream(ctx, gvr, namespace, name)
+}
+
+// getLiveDownstream gets the downstream object from the downstream cluster, or nil if it doesn't exist.
+func (c *Controller) getLiveDownstream(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	obj, err := c.getClient(gvr, namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return obj, err
+}
+
+// clusterLabelSelector restricts a list or watch to the objects assigned to the cluster.
+func (c *Controller) clusterLabelSelector(o *metav1.ListOptions) {
+	o.LabelSelector = fmt.Sprintf("kcp.dev/cluster=%s", c.clusterID)
+}
+
+// addInformer starts an upstream informer for the GVR, unless there is already one.
+// The informers of the spec syncer watch all the upstream objects, since objects are assigned to clusters
+// by labels and annotations which can't be selected at once server-side: their handlers filter the objects
+// assigned to the cluster.
+func (c *Controller) addInformer(gvr schema.GroupVersionResource) {
+	if c.direction == specDirection {
+		c.startInformer(gvr, nil, false)
+		return
+	}
+	c.startInformer(gvr, c.clusterLabelSelector, false)
+}
+
+// addPinnedInformer starts an upstream informer for the GVR, which is kept running
+// regardless of the discovered resource types.
+func (c *Controller) addPinnedInformer(gvr schema.GroupVersionResource, tweakListOptions dynamicinformer.TweakListOptionsFunc) {
+	c.startInformer(gvr, tweakListOptions, true)
+}
+
+// startInformer starts an upstream informer for the GVR.
+// Informers of all the upstream objects are shared with the spec syncers of other clusters, if any.
+func (c *Controller) startInformer(gvr schema.GroupVersionResource, tweakListOptions dynamicinformer.TweakListOptionsFunc, pinned bool) {
+	c.informersLock.Lock()
+	defer c.informersLock.Unlock()
+
+	if _, found := c.informers[gvr]; found {
+		return
+	}
+
+	handler := c.handlers(c, gvr)
+	ri := &resourceInformer{
+		stopCh: make(chan struct{}),
+		pinned: pinned,
+	}
+	if tweakListOptions == nil {
+		ri.informer = c.runUpstreamInformer(gvr, handler, ri.stopCh)
+	} else {
+		indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+		ri.informer = dynamicinformer.NewFilteredDynamicInformer(c.fromClient, gvr, metav1.NamespaceAll, resyncPeriod, indexers, tweakListOptions).Informer()
+		ri.informer.AddEventHandler(handler)
+		go ri.informer.Run(ri.stopCh)
+	}
+	c.informers[gvr] = ri
+	informer := ri.informer
+	go c.observeInformerSync(gvr, informer, ri.stopCh)
+	if c.downstreamHandlers != nil && !pinned {
+		c.startDownstreamInformer(gvr, ri)
+	}
+
+	klog.Infof("Set up informer for %v", gvr)
+}
+
+// watchDownstream makes the controller also watch the downstream objects of the synced GVRs
+// assigned to the cluster, with the given handlers.
+func (c *Controller) watchDownstream(handlers HandlersProvider) {
+	c.informersLock.Lock()
+	defer c.informersLock.Unlock()
+
+	c.downstreamHandlers = handlers
+	for gvr, ri := range c.informers {
+		if !ri.pinned && ri.downstream == nil {
+			c.startDownstreamInformer(gvr, ri)
+		}
+	}
+}
+
+// startDownstreamInformer starts the downstream informer of a resourceInformer, which is stopped along with it.
+// It must be called with the informers lock held.
+func (c *Controller) startDownstreamInformer(gvr schema.GroupVersionResource, ri *resourceInformer) {
+	ri.downstream = dynamicinformer.NewFilteredDynamicInformer(c.toClient, gvr, metav1.NamespaceAll, resyncPeriod, cache.Indexers{}, c.clusterLabelSelector).Informer()
+	ri.downstream.AddEventHandler(c.downstreamHandlers(c, gvr))
+	go ri.downstream.Run(ri.stopCh)
+
+	klog.Infof("Set up downstream informer for %v", gvr)
+}
+
+// removeInformer stops the upstream informer for the GVR.
+// Queued items for this GVR are dropped when they are processed.
+func (c *Controller) removeInformer(gvr schema.GroupVersionResource) {
+	c.informersLock.Lock()
+	defer c.informersLock.Unlock()
+
+	ri, found := c.informers[gvr]
+	if !found {
+		return
+	}
+	close(ri.stopCh)
+	delete(c.informers, gvr)
+
+	klog.Infof("Stopped informer for %v", gvr)
+}
+
+// syncedGVRs returns the GVRs of the resource types currently synced, excluding pinned informers.
+func (c *Controller) syncedGVRs() []schema.GroupVersionResource {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	var gvrs []schema.GroupVersionResource
+	for gvr, ri := range c.informers {
+		if !ri.pinned {
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	return gvrs
+}
+
+// stopInformers stops all the upstream informers.
+func (c *Controller) stopInformers() {
+	c.informersLock.Lock()
+	defer c.informersLock.Unlock()
+
+	for gvr, ri := range c.informers {
+		close(ri.stopCh)
+		delete(c.informers, gvr)
+	}
+}
+
+// setSyncedResourceTypes changes the resource types to sync, and refreshes
+// the upstream informers accordingly.
+func (c *Controller) setSyncedResourceTypes(syncedResourceTypes []string) {
+	c.informersLock.Lock()
+	c.syncedResourceTypes = syncedResourceTypes
+	c.informersLock.Unlock()
+
+	c.refreshInformers()
+}
+
+// refreshInformers runs discovery against the upstream API server, starts informers
+// for newly available resource types, and stops informers for resource types
+// that are not served anymore.
+func (c *Controller) refreshInformers() {
+	c.informersLock.RLock()
+	syncedResourceTypes := c.syncedResourceTypes
+	c.informersLock.RUnlock()
+
+	gvrstrs, notFoundResourceTypes, err := discoverGVRs(c.fromDiscovery, c.clusterScopedResources, syncedResourceTypes...)
+	if err != nil {
+		klog.Errorf("Error discovering resource types to sync: %v", err)
+		return
+	}
+	if notFoundResourceTypes.Len() != 0 {
+		klog.V(2).Infof("The following resource types were requested to be synced, but were not found: %v", notFoundResourceTypes.List())
+	}
+
+	// Kinds of owners and status subresources may have been changed along with resource types.
+	c.restMapper.Reset()
+	if c.statusSubresources != nil {
+		c.statusSubresources.reset()
+	}
+
+	discovered := map[schema.GroupVersionResource]bool{}
+	for _, gvrstr := range gvrstrs {
+		gvr, _ := schema.ParseResourceArg(gvrstr)
+		discovered[*gvr] = true
+		c.addInformer(*gvr)
+	}
+
+	c.informersLock.RLock()
+	var removed []schema.GroupVersionResource
+	for gvr, ri := range c.informers {
+		if !discovered[gvr] && !ri.pinned {
+			removed = append(removed, gvr)
+		}
+	}
+	c.informersLock.RUnlock()
+
+	for _, gvr := range removed {
+		c.removeInformer(gvr)
+	}
+}
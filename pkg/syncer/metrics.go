@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// specDirection labels the metrics of the spec syncer, from upstream to downstream.
+	specDirection = "spec"
+	// statusDirection labels the metrics of the status syncer, from downstream to upstream.
+	statusDirection = "status"
+)
+
+var (
+	syncTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "syncer",
+			Name:           "sync_total",
+			Help:           "Number of objects synced, by cluster, direction, resource and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource", "result"},
+	)
+	syncDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      "syncer",
+			Name:           "sync_duration_seconds",
+			Help:           "Duration of the sync of an object, by cluster, direction and resource.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource"},
+	)
+	droppedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "syncer",
+			Name:           "dropped_total",
+			Help:           "Number of objects dropped from the queue after failed retries, by cluster, direction and resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource"},
+	)
+	informerSynced = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      "syncer",
+			Name:           "informer_synced",
+			Help:           "Whether the informer of a resource has synced, by cluster, direction and resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource"},
+	)
+	queueDepth = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      "syncer",
+			Name:           "queue_depth",
+			Help:           "Number of objects waiting in the queue, by cluster, direction and resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource"},
+	)
+	queueAddsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "syncer",
+			Name:           "queue_adds_total",
+			Help:           "Number of objects added to the queue, by cluster, direction and resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"cluster", "direction", "resource"},
+	)
+)
+
+// syncResults are the values of the result label of syncTotal.
+var syncResults = []string{"success", "error"}
+
+var registerMetrics sync.Once
+
+// RegisterMetrics registers the syncer metrics in the legacy registry, served on /metrics.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(syncTotal)
+		legacyregistry.MustRegister(syncDuration)
+		legacyregistry.MustRegister(droppedTotal)
+		legacyregistry.MustRegister(informerSynced)
+		legacyregistry.MustRegister(queueDepth)
+		legacyregistry.MustRegister(queueAddsTotal)
+	})
+}
+
+// queueKeyGVR returns the GVR of a queue item.
+func queueKeyGVR(i interface{}) schema.GroupVersionResource {
+	switch key := i.(type) {
+	case driftKey:
+		return key.gvr
+	case dependencyKey:
+		return key.gvr
+	case holder:
+		return key.gvr
+	}
+	return schema.GroupVersionResource{}
+}
+
+// observeSync records the result and duration of the sync of a queue item.
+// Items processed while the controller stops are not recorded, since its series are deleted.
+func (c *Controller) observeSync(i interface{}, start time.Time, err error) {
+	if c.queue.ShuttingDown() {
+		return
+	}
+	resource := queueKeyGVR(i).GroupResource().String()
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	syncTotal.WithLabelValues(c.clusterID, c.direction, resource, result).Inc()
+	syncDuration.WithLabelValues(c.clusterID, c.direction, resource).Observe(time.Since(start).Seconds())
+}
+
+// observeDrop records a queue item dropped after failed retries.
+func (c *Controller) observeDrop(i interface{}) {
+	if c.queue.ShuttingDown() {
+		return
+	}
+	droppedTotal.WithLabelValues(c.clusterID, c.direction, queueKeyGVR(i).GroupResource().String()).Inc()
+}
+
+// observeInformerSync records whether the informer of the GVR has synced, once it has synced or is stopped.
+func (c *Controller) observeInformerSync(gvr schema.GroupVersionResource, informer cache.SharedIndexInformer, stopCh <-chan struct{}) {
+	gauge := informerSynced.WithLabelValues(c.clusterID, c.direction, gvr.GroupResource().String())
+	gauge.Set(0)
+	if cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		gauge.Set(1)
+	}
+	<-stopCh
+	informerSynced.Delete(map[string]string{"cluster": c.clusterID, "direction": c.direction, "resource": gvr.GroupResource().String()})
+}
+
+// observeAdd records an item added to its queue, and the depth of the queue.
+func (q *resourceQueues) observeAdd(item interface{}, queue workqueue.RateLimitingInterface) {
+	if q.ShuttingDown() {
+		return
+	}
+	resource := queueKeyGVR(item).GroupResource().String()
+	queueAddsTotal.WithLabelValues(q.cluster, q.direction, resource).Inc()
+	queueDepth.WithLabelValues(q.cluster, q.direction, resource).Set(float64(queue.Len()))
+}
+
+// observeDepth records the depth of the queue of an item taken from it.
+func (q *resourceQueues) observeDepth(item interface{}, queue workqueue.RateLimitingInterface) {
+	if q.ShuttingDown() {
+		return
+	}
+	queueDepth.WithLabelValues(q.cluster, q.direction, queueKeyGVR(item).GroupResource().String()).Set(float64(queue.Len()))
+}
+
+// deleteMetrics deletes the series of the stopped controller, which would otherwise be exported forever
+// for clusters which are not synced anymore. The series of the informers are deleted when they stop.
+func (c *Controller) deleteMetrics() {
+	for _, gvr := range c.queue.gvrs() {
+		labels := map[string]string{"cluster": c.clusterID, "direction": c.direction, "resource": gvr.GroupResource().String()}
+		syncDuration.Delete(labels)
+		droppedTotal.Delete(labels)
+		queueDepth.Delete(labels)
+		queueAddsTotal.Delete(labels)
+		for _, result := range syncResults {
+			syncTotal.Delete(map[string]string{"cluster": c.clusterID, "direction": c.direction, "resource": gvr.GroupResource().String(), "result": result})
+		}
+	}
+}
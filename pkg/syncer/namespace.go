@@ -0,0 +1,87 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// LogicalClusterAnnotation records, on downstream objects, the logical cluster of the upstream object.
+	LogicalClusterAnnotation = "kcp.dev/logical-cluster"
+	// NamespaceAnnotation records, on downstream objects, the namespace of the upstream object.
+	NamespaceAnnotation = "kcp.dev/namespace"
+)
+
+const (
+	// NamespaceMappingIdentity keeps the upstream namespace name downstream.
+	NamespaceMappingIdentity = "identity"
+	// NamespaceMappingPrefixed prefixes the upstream namespace name with the logical cluster name downstream.
+	NamespaceMappingPrefixed = "prefixed"
+)
+
+// NamespaceMapper maps namespaces of upstream logical clusters to namespaces of the downstream physical cluster.
+type NamespaceMapper interface {
+	// DownstreamNamespace returns the downstream namespace for the given namespace of the logical cluster.
+	DownstreamNamespace(logicalCluster, namespace string) string
+}
+
+// NewNamespaceMapper returns the NamespaceMapper of the given namespace mapping.
+// The identity mapping is used if the namespace mapping is empty.
+func NewNamespaceMapper(namespaceMapping string) (NamespaceMapper, error) {
+	switch namespaceMapping {
+	case "", NamespaceMappingIdentity:
+		return identityNamespaceMapper{}, nil
+	case NamespaceMappingPrefixed:
+		return prefixedNamespaceMapper{}, nil
+	default:
+		return nil, fmt.Errorf("unknown namespace mapping %q", namespaceMapping)
+	}
+}
+
+type identityNamespaceMapper struct{}
+
+func (identityNamespaceMapper) DownstreamNamespace(logicalCluster, namespace string) string {
+	return namespace
+}
+
+// prefixedNamespaceMapper maps namespaces to kcp-<logical cluster>-<namespace>, so that
+// namespaces with the same name in several logical clusters do not collide downstream.
+//
+// A hash of the logical cluster and namespace is appended when the result would be
+// too long for a namespace name, or ambiguous because the logical cluster name
+// contains a dash.
+type prefixedNamespaceMapper struct{}
+
+func (prefixedNamespaceMapper) DownstreamNamespace(logicalCluster, namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	name := "kcp-" + logicalCluster + "-" + namespace
+	if len(name) <= validation.DNS1123LabelMaxLength && !strings.Contains(logicalCluster, "-") {
+		return name
+	}
+	hash := sha256.Sum256([]byte(logicalCluster + "/" + namespace))
+	suffix := hex.EncodeToString(hash[:])[:10]
+	if maxLength := validation.DNS1123LabelMaxLength - len(suffix) - 1; len(name) > maxLength {
+		name = name[:maxLength]
+	}
+	return name + "-" + suffix
+}
+
+// upstreamNamespace returns the upstream namespace of a downstream object, as recorded in its annotations.
+func upstreamNamespace(unstrob *unstructured.Unstructured) string {
+	if namespace, exists := unstrob.GetAnnotations()[NamespaceAnnotation]; exists {
+		return namespace
+	}
+	return unstrob.GetNamespace()
+}
+
+// downstreamNamespace returns the downstream namespace of an upstream namespace in the logical cluster.
+func (c *Controller) downstreamNamespace(logicalCluster, namespace string) string {
+	return c.namespaceMapper.DownstreamNamespace(logicalCluster, namespace)
+}
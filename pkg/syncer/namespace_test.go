@@ -0,0 +1,52 @@
+package syncer
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestPrefixedNamespaceMapper(t *testing.T) {
+	mapper, err := NewNamespaceMapper(NamespaceMappingPrefixed)
+	if err != nil {
+		t.Fatalf("NewNamespaceMapper() = %v", err)
+	}
+
+	if got, want := mapper.DownstreamNamespace("admin", "default"), "kcp-admin-default"; got != want {
+		t.Errorf("DownstreamNamespace(admin, default) = %v, want %v", got, want)
+	}
+
+	if got := mapper.DownstreamNamespace("admin", ""); got != "" {
+		t.Errorf("DownstreamNamespace(admin, \"\") = %v, want empty namespace", got)
+	}
+
+	// A dash in the logical cluster name makes the prefixed name ambiguous.
+	ambiguous1 := mapper.DownstreamNamespace("a-b", "c")
+	ambiguous2 := mapper.DownstreamNamespace("a", "b-c")
+	if ambiguous1 == ambiguous2 {
+		t.Errorf("DownstreamNamespace(a-b, c) and DownstreamNamespace(a, b-c) are both %v", ambiguous1)
+	}
+
+	long := mapper.DownstreamNamespace("admin", strings.Repeat("n", validation.DNS1123LabelMaxLength))
+	if errs := validation.IsDNS1123Label(long); len(errs) != 0 {
+		t.Errorf("DownstreamNamespace(admin, <long namespace>) = %v, which is not a valid namespace name: %v", long, errs)
+	}
+	if other := mapper.DownstreamNamespace("admin", strings.Repeat("n", validation.DNS1123LabelMaxLength-1)); other == long {
+		t.Errorf("DownstreamNamespace() of different long namespaces are both %v", long)
+	}
+}
+
+func TestNewNamespaceMapper(t *testing.T) {
+	mapper, err := NewNamespaceMapper("")
+	if err != nil {
+		t.Fatalf("NewNamespaceMapper(\"\") = %v", err)
+	}
+	if got, want := mapper.DownstreamNamespace("admin", "default"), "default"; got != want {
+		t.Errorf("DownstreamNamespace(admin, default) = %v, want %v", got, want)
+	}
+
+	if _, err := NewNamespaceMapper("unknown"); err == nil {
+		t.Errorf("NewNamespaceMapper(unknown) should fail")
+	}
+}
@@ -0,0 +1,249 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+var namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+const (
+	// namespaceRecheckInterval is how often the spec syncer checks whether a downstream namespace
+	// of a deleted upstream namespace is empty, and can be deleted.
+	namespaceRecheckInterval = 10 * time.Second
+
+	// NamespaceTerminatingConditionTypePrefix prefixes the type of the condition set on an upstream namespace
+	// whose downstream namespace is terminating, followed by the cluster ID.
+	NamespaceTerminatingConditionTypePrefix = "DownstreamNamespaceTerminating-"
+)
+
+// NamespaceTerminatingConditionType returns the type of the condition set on an upstream namespace
+// whose downstream namespace on the cluster is terminating, so that each cluster syncing it reports its own.
+func NamespaceTerminatingConditionType(clusterID string) string {
+	return NamespaceTerminatingConditionTypePrefix + clusterID
+}
+
+// withNamespaceSync wraps the UpsertFunc and DeleteFunc of the spec syncer,
+// so that upstream namespaces are synced with their own lifecycle.
+func withNamespaceSync(upsertFn UpsertFunc, deleteFn DeleteFunc) (UpsertFunc, DeleteFunc) {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+			if gvr == namespacesGVR {
+				return c.syncNamespace(ctx, unstrob)
+			}
+			return upsertFn(c, ctx, gvr, namespace, unstrob)
+		}, func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, meta metav1.Object) error {
+			if gvr == namespacesGVR {
+				return c.deleteDownstreamNamespace(ctx, meta.GetClusterName(), meta.GetName())
+			}
+			return deleteFn(c, ctx, gvr, namespace, meta)
+		}
+}
+
+// withNamespaceStatus wraps the UpsertFunc of the status syncer,
+// so that the status of downstream namespaces is reflected on upstream namespaces.
+func withNamespaceStatus(upsertFn UpsertFunc) UpsertFunc {
+	return func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+		if gvr == namespacesGVR {
+			return c.updateNamespaceStatusInUpstream(ctx, unstrob)
+		}
+		return upsertFn(c, ctx, gvr, namespace, unstrob)
+	}
+}
+
+// namespaceForDownstream returns the downstream namespace of an upstream namespace,
+// with the labels and annotations of the upstream namespace, if any.
+func (c *Controller) namespaceForDownstream(logicalCluster, namespace string, upstreamNamespace *unstructured.Unstructured) *unstructured.Unstructured {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	if upstreamNamespace != nil {
+		for k, v := range upstreamNamespace.GetLabels() {
+			labels[k] = v
+		}
+		for k, v := range upstreamNamespace.GetAnnotations() {
+			annotations[k] = v
+		}
+	}
+	labels["kcp.dev/cluster"] = c.clusterID
+	annotations[LogicalClusterAnnotation] = logicalCluster
+	annotations[NamespaceAnnotation] = namespace
+
+	newNamespace := &unstructured.Unstructured{}
+	newNamespace.SetAPIVersion("v1")
+	newNamespace.SetKind("Namespace")
+	newNamespace.SetName(c.downstreamNamespace(logicalCluster, namespace))
+	newNamespace.SetLabels(labels)
+	newNamespace.SetAnnotations(annotations)
+	return newNamespace
+}
+
+// ownsNamespace returns whether the downstream namespace was created by the syncer for the logical cluster.
+// Namespaces which were not created by the syncer are never updated or deleted.
+func (c *Controller) ownsNamespace(downstreamNamespace *unstructured.Unstructured, logicalCluster string) bool {
+	return downstreamNamespace.GetLabels()["kcp.dev/cluster"] == c.clusterID &&
+		downstreamNamespace.GetAnnotations()[LogicalClusterAnnotation] == logicalCluster
+}
+
+// getUpstreamNamespace returns the upstream namespace from the informer cache, or nil if it doesn't exist.
+func (c *Controller) getUpstreamNamespace(logicalCluster, namespace string) (*unstructured.Unstructured, error) {
+	informer, found := c.getInformer(namespacesGVR)
+	if !found {
+		return nil, nil
+	}
+	return getFromCache(informer, logicalCluster, "", namespace)
+}
+
+// ensureNamespaceExists creates the downstream namespace of an upstream namespace, if it doesn't exist yet.
+func (c *Controller) ensureNamespaceExists(ctx context.Context, logicalCluster, namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	upstreamNamespace, err := c.getUpstreamNamespace(logicalCluster, namespace)
+	if err != nil {
+		return err
+	}
+	newNamespace := c.namespaceForDownstream(logicalCluster, namespace, upstreamNamespace)
+	if _, err := c.getClient(namespacesGVR, "").Create(ctx, newNamespace, metav1.CreateOptions{}); err != nil {
+		if !k8serrors.IsAlreadyExists(err) {
+			klog.Infof("Error while creating namespace %s: %v", newNamespace.GetName(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// syncNamespace propagates the labels and annotations of an upstream namespace to its downstream namespace,
+// or deletes the downstream namespace if the upstream namespace is being deleted.
+// Downstream namespaces are only created when the first object is synced into them.
+func (c *Controller) syncNamespace(ctx context.Context, upstreamNamespace *unstructured.Unstructured) error {
+	logicalCluster, namespace := upstreamNamespace.GetClusterName(), upstreamNamespace.GetName()
+	if upstreamNamespace.GetDeletionTimestamp() != nil {
+		return c.deleteDownstreamNamespace(ctx, logicalCluster, namespace)
+	}
+
+	client := c.getClient(namespacesGVR, "")
+	desired := c.namespaceForDownstream(logicalCluster, namespace, upstreamNamespace)
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.ownsNamespace(existing, logicalCluster) {
+		return nil
+	}
+	if equality.Semantic.DeepEqual(existing.GetLabels(), desired.GetLabels()) &&
+		equality.Semantic.DeepEqual(existing.GetAnnotations(), desired.GetAnnotations()) {
+		return nil
+	}
+
+	existing = existing.DeepCopy()
+	existing.SetLabels(desired.GetLabels())
+	existing.SetAnnotations(desired.GetAnnotations())
+	if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Updating namespace %s: %v", existing.GetName(), err)
+		return err
+	}
+	klog.Infof("Updated namespace %s", existing.GetName())
+	return nil
+}
+
+// deleteDownstreamNamespace deletes the downstream namespace of a deleted upstream namespace,
+// once no synced object remains in it.
+func (c *Controller) deleteDownstreamNamespace(ctx context.Context, logicalCluster, namespace string) error {
+	client := c.getClient(namespacesGVR, "")
+	name := c.downstreamNamespace(logicalCluster, namespace)
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.ownsNamespace(existing, logicalCluster) || existing.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	empty, err := c.isDownstreamNamespaceEmpty(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		klog.V(2).Infof("Namespace %s still contains synced objects", name)
+		key := &unstructured.Unstructured{}
+		key.SetName(namespace)
+		key.SetClusterName(logicalCluster)
+		c.recheckLater(holder{gvr: namespacesGVR, obj: key}, namespaceRecheckInterval, "downstream namespace still contains synced objects")
+		return nil
+	}
+
+	uid := existing.GetUID()
+	if err := client.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	}); err != nil && !k8serrors.IsNotFound(err) {
+		klog.Errorf("Deleting namespace %s: %v", name, err)
+		return err
+	}
+	klog.Infof("Deleted namespace %s", name)
+	return nil
+}
+
+// isDownstreamNamespaceEmpty returns whether no object of the synced resource types,
+// assigned to the cluster, remains in the downstream namespace.
+func (c *Controller) isDownstreamNamespaceEmpty(ctx context.Context, namespace string) (bool, error) {
+	for _, gvr := range c.syncedGVRs() {
+		list, err := c.getClient(gvr, namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("kcp.dev/cluster=%s", c.clusterID),
+			Limit:         1,
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(list.Items) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// updateNamespaceStatusInUpstream reflects whether a downstream namespace is terminating
+// in a condition of the upstream namespace.
+func (c *Controller) updateNamespaceStatusInUpstream(ctx context.Context, downstreamNamespace *unstructured.Unstructured) error {
+	logicalCluster := downstreamNamespace.GetAnnotations()[LogicalClusterAnnotation]
+	name, exists := downstreamNamespace.GetAnnotations()[NamespaceAnnotation]
+	if !exists {
+		name = downstreamNamespace.GetName()
+	}
+
+	client := c.getClient(namespacesGVR, "")
+	upstreamNamespace, err := client.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if logicalCluster != "" && upstreamNamespace.GetClusterName() != logicalCluster {
+		return nil
+	}
+
+	phase, _, err := unstructured.NestedString(downstreamNamespace.Object, "status", "phase")
+	if err != nil {
+		return err
+	}
+	status, reason := "False", "Active"
+	if phase == "Terminating" {
+		status, reason = "True", "Terminating"
+	}
+	return c.updateCondition(ctx, namespacesGVR, client, upstreamNamespace, NamespaceTerminatingConditionType(c.clusterID), status, reason,
+		fmt.Sprintf("Namespace %s is %s on cluster %s", downstreamNamespace.GetName(), phase, c.clusterID))
+}
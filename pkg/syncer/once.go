@@ -0,0 +1,297 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// syncedPollInterval is how often WaitUntilSynced checks whether the syncer has converged.
+const syncedPollInterval = time.Second
+
+// SyncSummary is the outcome of a full reconciliation of the objects assigned to a cluster.
+type SyncSummary struct {
+	// Objects is the number of upstream objects assigned to the cluster, by resource type.
+	Objects map[string]int
+	// Failures are the error messages of the objects which failed to sync, by object.
+	Failures map[string]string
+	// Blocked are the reasons why objects were still waiting to be synced or deleted, by object.
+	Blocked map[string]string
+	// Converged is false if the syncer was still syncing objects or waiting for statuses when it timed out.
+	Converged bool
+}
+
+// Failed returns whether the reconciliation didn't complete successfully.
+func (s *SyncSummary) Failed() bool {
+	return !s.Converged || len(s.Failures) > 0 || len(s.Blocked) > 0
+}
+
+// String formats the summary for humans, one line per resource type and failure.
+func (s *SyncSummary) String() string {
+	var lines []string
+	for resource, count := range s.Objects {
+		lines = append(lines, fmt.Sprintf("synced %d %s", count, resource))
+	}
+	sort.Strings(lines)
+	var failures []string
+	for object, message := range s.Failures {
+		failures = append(failures, fmt.Sprintf("failed %s: %s", object, message))
+	}
+	for object, reason := range s.Blocked {
+		failures = append(failures, fmt.Sprintf("blocked %s: %s", object, reason))
+	}
+	sort.Strings(failures)
+	lines = append(lines, failures...)
+	if !s.Converged {
+		lines = append(lines, "timed out before all objects were synced and their statuses copied back")
+	}
+	result := ""
+	for _, line := range lines {
+		result += line + "\n"
+	}
+	return result
+}
+
+// WaitUntilSynced waits until the syncer has synced every object assigned to the cluster, cleaned up
+// orphaned downstream objects and copied the statuses of the downstream objects back upstream,
+// or until the context is done, and returns a summary of the reconciliation.
+//
+// Objects which keep failing to sync don't prevent convergence: they are reported in the summary.
+// In once mode, objects waiting to be checked again later, e.g. for their owners to be synced, don't
+// either: they are retried once the queues are idle, and reported as blocked if they are still waiting.
+func (s *Syncer) WaitUntilSynced(ctx context.Context) *SyncSummary {
+	// The queues must be seen idle twice in a row, since informer events may still be
+	// on their way to the queues when they are seen idle for the first time.
+	idle, retried := false, false
+	err := wait.PollImmediateUntil(syncedPollInterval, func() (bool, error) {
+		if !s.specSyncer.converged() || !s.statusSyncer.converged() || !s.statusesObserved() {
+			idle = false
+			return false, nil
+		}
+		if !idle {
+			idle = true
+			return false, nil
+		}
+		if !retried {
+			retried = true
+			if s.specSyncer.retryBlocked()+s.statusSyncer.retryBlocked() > 0 {
+				idle = false
+				return false, nil
+			}
+		}
+		return true, nil
+	}, ctx.Done())
+
+	summary := &SyncSummary{
+		Objects:   s.specSyncer.assignedObjects(),
+		Failures:  map[string]string{},
+		Blocked:   map[string]string{},
+		Converged: err == nil,
+	}
+	for _, c := range []*Controller{s.specSyncer, s.statusSyncer} {
+		c.failuresLock.Lock()
+		for item, message := range c.failures {
+			summary.Failures[item] = message
+		}
+		c.failuresLock.Unlock()
+
+		c.blockedLock.Lock()
+		for item, blocked := range c.blocked {
+			summary.Blocked[item] = blocked.reason
+		}
+		c.blockedLock.Unlock()
+	}
+	return summary
+}
+
+// converged returns whether the informers of the controller have synced, its startup cleanup
+// is complete and it has nothing left to process.
+func (c *Controller) converged() bool {
+	if c.checkInformersSynced(nil) != nil {
+		return false
+	}
+	if c.orphansCleanedUp != nil {
+		select {
+		case <-c.orphansCleanedUp:
+		default:
+			return false
+		}
+	}
+	return c.queue.idle()
+}
+
+// statusesObserved returns whether every downstream object reporting an observed generation
+// has observed its latest generation, and its status was written upstream, so that the upstream
+// status is up to date.
+func (s *Syncer) statusesObserved() bool {
+	c := s.statusSyncer
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	for gvr, ri := range c.informers {
+		if ri.pinned {
+			continue
+		}
+		upstreamObjs := s.specSyncer.upstreamObjects(gvr)
+		for _, obj := range ri.informer.GetStore().List() {
+			unstrob, isUnstructured := obj.(*unstructured.Unstructured)
+			if !isUnstructured {
+				continue
+			}
+			observed, found, err := unstructured.NestedInt64(unstrob.Object, "status", "observedGeneration")
+			if err != nil || !found {
+				continue
+			}
+			if observed < unstrob.GetGeneration() {
+				klog.V(2).Infof("Waiting for the status of %s %s/%s to observe generation %d", gvr.Resource, unstrob.GetNamespace(), unstrob.GetName(), unstrob.GetGeneration())
+				return false
+			}
+			upstreamGeneration, err := strconv.ParseInt(unstrob.GetAnnotations()[UpstreamGenerationAnnotation], 10, 64)
+			if err != nil {
+				continue
+			}
+			upstreamObj, found := upstreamObjs[upstreamObjectKey(unstrob.GetAnnotations()[LogicalClusterAnnotation], upstreamNamespace(unstrob), unstrob.GetName())]
+			if !found {
+				continue
+			}
+			if upstreamObserved, found := c.upstreamObservedGeneration(upstreamObj); !found || upstreamObserved < upstreamGeneration {
+				klog.V(2).Infof("Waiting for the status of %s %s/%s to be written upstream", gvr.Resource, unstrob.GetNamespace(), unstrob.GetName())
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// upstreamObjects returns the upstream objects of the GVR in the informer of the controller, by upstreamObjectKey.
+func (c *Controller) upstreamObjects(gvr schema.GroupVersionResource) map[string]*unstructured.Unstructured {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	objects := map[string]*unstructured.Unstructured{}
+	ri, found := c.informers[gvr]
+	if !found {
+		return objects
+	}
+	for _, obj := range ri.informer.GetStore().List() {
+		if unstrob, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+			objects[upstreamObjectKey(unstrob.GetClusterName(), unstrob.GetNamespace(), unstrob.GetName())] = unstrob
+		}
+	}
+	return objects
+}
+
+func upstreamObjectKey(logicalCluster, namespace, name string) string {
+	return logicalCluster + "|" + namespace + "/" + name
+}
+
+// upstreamObservedGeneration returns the observed generation written in the status of an upstream object
+// by the status syncer, which is in the status annotation of the cluster for objects assigned to several locations.
+func (c *Controller) upstreamObservedGeneration(upstreamObj *unstructured.Unstructured) (int64, bool) {
+	if assignedLocations(upstreamObj).Len() <= 1 {
+		observed, found, err := unstructured.NestedInt64(upstreamObj.Object, "status", "observedGeneration")
+		return observed, found && err == nil
+	}
+	value, found := upstreamObj.GetAnnotations()[locationStatusAnnotationPrefix+c.clusterID]
+	if !found {
+		return 0, false
+	}
+	var locationStatus struct {
+		ObservedGeneration *int64 `json:"observedGeneration"`
+	}
+	if err := json.Unmarshal([]byte(value), &locationStatus); err != nil || locationStatus.ObservedGeneration == nil {
+		return 0, false
+	}
+	return *locationStatus.ObservedGeneration, true
+}
+
+// assignedObjects returns the number of upstream objects assigned to the cluster, by resource type.
+func (c *Controller) assignedObjects() map[string]int {
+	c.informersLock.RLock()
+	defer c.informersLock.RUnlock()
+
+	objects := map[string]int{}
+	for gvr, ri := range c.informers {
+		for _, obj := range ri.informer.GetStore().List() {
+			if c.isAssigned(obj) {
+				objects[gvr.GroupResource().String()]++
+			}
+		}
+	}
+	return objects
+}
+
+// describeItem returns a human-readable description of a queue item.
+func describeItem(i interface{}) string {
+	resource := queueKeyGVR(i).GroupResource().String()
+	switch key := i.(type) {
+	case driftKey:
+		return fmt.Sprintf("%s %s|%s/%s", resource, key.logicalCluster, key.namespace, key.name)
+	case dependencyKey:
+		return fmt.Sprintf("%s %s|%s/%s", resource, key.logicalCluster, key.namespace, key.name)
+	case holder:
+		if meta, isMeta := key.obj.(metav1.Object); isMeta {
+			return fmt.Sprintf("%s %s|%s/%s", resource, meta.GetClusterName(), meta.GetNamespace(), meta.GetName())
+		}
+		return fmt.Sprintf("%s %v", resource, key.obj)
+	}
+	return fmt.Sprintf("%v", i)
+}
+
+// recordFailure records that the queue item failed to sync, until it is synced successfully.
+func (c *Controller) recordFailure(i interface{}, err error) {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+	c.failures[describeItem(i)] = err.Error()
+}
+
+// blockedItem is a queue item waiting to be checked again later, in once mode.
+type blockedItem struct {
+	item   interface{}
+	reason string
+}
+
+// recheckLater queues the item again after the duration, or records it as blocked for the reason in once mode,
+// since the item may wait for longer than the reconciliation.
+func (c *Controller) recheckLater(i interface{}, after time.Duration, reason string) {
+	if !c.once {
+		c.queue.AddAfter(i, after)
+		return
+	}
+	c.blockedLock.Lock()
+	defer c.blockedLock.Unlock()
+	c.blocked[describeItem(i)] = blockedItem{item: i, reason: reason}
+}
+
+// clearBlocked forgets that a queue item was blocked, when it is processed again.
+func (c *Controller) clearBlocked(i interface{}) {
+	c.blockedLock.Lock()
+	defer c.blockedLock.Unlock()
+	delete(c.blocked, describeItem(i))
+}
+
+// retryBlocked queues the blocked items again, and returns their number.
+func (c *Controller) retryBlocked() int {
+	c.blockedLock.Lock()
+	defer c.blockedLock.Unlock()
+	for _, blocked := range c.blocked {
+		c.queue.Add(blocked.item)
+	}
+	return len(c.blocked)
+}
+
+// clearFailure forgets the failure of a queue item which was synced successfully.
+func (c *Controller) clearFailure(i interface{}) {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+	delete(c.failures, describeItem(i))
+}
@@ -0,0 +1,167 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// OrphanPolicyDelete makes the spec syncer delete, on startup, the downstream objects
+	// assigned to the cluster which have no upstream object anymore.
+	OrphanPolicyDelete = "delete"
+	// OrphanPolicyReport makes the spec syncer only log such downstream objects.
+	OrphanPolicyReport = "report"
+)
+
+// validateOrphanPolicy returns an error if the orphan policy is unknown.
+func validateOrphanPolicy(orphanPolicy string) error {
+	switch orphanPolicy {
+	case "", OrphanPolicyDelete, OrphanPolicyReport:
+		return nil
+	default:
+		return fmt.Errorf("unknown orphan policy %q", orphanPolicy)
+	}
+}
+
+// cleanupOrphans waits for the initial sync of the upstream and downstream informers, and then deletes
+// or reports the downstream objects assigned to the cluster whose upstream object doesn't exist anymore,
+// e.g. because it was deleted while the syncer was down.
+// Downstream namespaces created by the syncer are deleted once they are empty.
+func (c *Controller) cleanupOrphans(orphanPolicy string) {
+	defer close(c.orphansCleanedUp)
+	ctx := context.TODO()
+
+	for _, gvr := range c.syncedGVRs() {
+		informer, found := c.getInformer(gvr)
+		if !found {
+			continue
+		}
+		downstreamInformer, found := c.getDownstreamInformer(gvr)
+		if !found {
+			continue
+		}
+		if !cache.WaitForCacheSync(c.stopCh, informer.HasSynced, downstreamInformer.HasSynced) {
+			return
+		}
+		for _, obj := range downstreamInformer.GetStore().List() {
+			downstream, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			logicalCluster, found := downstream.GetAnnotations()[LogicalClusterAnnotation]
+			if !found || logicalCluster != c.logicalCluster {
+				// Not synced by a syncer which records the logical cluster, or synced from another logical cluster.
+				continue
+			}
+			if _, isDependency := downstream.GetAnnotations()[DependencyAnnotation]; isDependency {
+				// Not assigned to the cluster, but maybe referenced by a synced workload: see cleanupOrphanedDependencies.
+				continue
+			}
+			upstream, err := getFromCache(informer, logicalCluster, upstreamNamespace(downstream), downstream.GetName())
+			if err != nil {
+				klog.Errorf("Getting upstream object of %s %s/%s: %v", gvr.Resource, downstream.GetNamespace(), downstream.GetName(), err)
+				continue
+			}
+			if upstream != nil && c.isAssigned(upstream) {
+				continue
+			}
+			if orphanPolicy == OrphanPolicyReport {
+				klog.Warningf("Downstream object %s %s/%s has no upstream object assigned to the cluster in logical cluster %s", gvr.Resource, downstream.GetNamespace(), downstream.GetName(), logicalCluster)
+				continue
+			}
+			klog.Infof("Deleting orphaned downstream object %s %s/%s", gvr.Resource, downstream.GetNamespace(), downstream.GetName())
+			if _, err := c.deleteDownstream(ctx, gvr, logicalCluster, downstream.GetNamespace(), downstream.GetName(), ""); err != nil {
+				klog.Errorf("Deleting orphaned downstream object %s %s/%s: %v", gvr.Resource, downstream.GetNamespace(), downstream.GetName(), err)
+			}
+		}
+	}
+
+	if c.dependencies != nil {
+		if !c.rebuildReferences(ctx) {
+			return
+		}
+		c.cleanupOrphanedDependencies(ctx, orphanPolicy)
+	}
+	c.cleanupOrphanedNamespaces(ctx, orphanPolicy)
+}
+
+// cleanupOrphanedDependencies deletes or reports the downstream objects synced as dependencies
+// which no synced workload references anymore, e.g. because the workloads were deleted while the syncer was down.
+func (c *Controller) cleanupOrphanedDependencies(ctx context.Context, orphanPolicy string) {
+	for _, gvr := range dependencyGVRs {
+		opts := metav1.ListOptions{}
+		c.clusterLabelSelector(&opts)
+		list, err := c.getClient(gvr, "").List(ctx, opts)
+		if err != nil {
+			klog.Errorf("Listing downstream %s: %v", gvr.Resource, err)
+			continue
+		}
+		for i := range list.Items {
+			downstream := &list.Items[i]
+			if _, isDependency := downstream.GetAnnotations()[DependencyAnnotation]; !isDependency {
+				continue
+			}
+			if logicalCluster, found := downstream.GetAnnotations()[LogicalClusterAnnotation]; !found || logicalCluster != c.logicalCluster {
+				// Referenced by the workloads of another logical cluster.
+				continue
+			}
+			key := dependencyKey{
+				gvr:            gvr,
+				logicalCluster: c.logicalCluster,
+				namespace:      upstreamNamespace(downstream),
+				name:           downstream.GetName(),
+			}
+			if c.dependencies.isReferenced(key) {
+				continue
+			}
+			if orphanPolicy == OrphanPolicyReport {
+				klog.Warningf("Downstream dependency %s %s/%s is not referenced by any synced workload", gvr.Resource, downstream.GetNamespace(), downstream.GetName())
+				continue
+			}
+			klog.Infof("Deleting unreferenced downstream dependency %s %s/%s", gvr.Resource, downstream.GetNamespace(), downstream.GetName())
+			if err := c.processDependency(ctx, key); err != nil {
+				klog.Errorf("Deleting unreferenced downstream dependency %s %s/%s: %v", gvr.Resource, downstream.GetNamespace(), downstream.GetName(), err)
+			}
+		}
+	}
+}
+
+// cleanupOrphanedNamespaces deletes or reports the downstream namespaces created by the syncer
+// whose upstream namespace doesn't exist anymore.
+func (c *Controller) cleanupOrphanedNamespaces(ctx context.Context, orphanPolicy string) {
+	informer, found := c.getInformer(namespacesGVR)
+	if !found || !cache.WaitForCacheSync(c.stopCh, informer.HasSynced) {
+		return
+	}
+	list, err := c.getClient(namespacesGVR, "").List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("kcp.dev/cluster=%s", c.clusterID),
+	})
+	if err != nil {
+		klog.Errorf("Listing downstream namespaces: %v", err)
+		return
+	}
+	for i := range list.Items {
+		downstream := &list.Items[i]
+		logicalCluster := c.logicalCluster
+		if !c.ownsNamespace(downstream, logicalCluster) {
+			continue
+		}
+		namespace := upstreamNamespace(downstream)
+		upstream, err := getFromCache(informer, logicalCluster, "", namespace)
+		if err != nil || upstream != nil {
+			continue
+		}
+		if orphanPolicy == OrphanPolicyReport {
+			klog.Warningf("Downstream namespace %s has no upstream namespace in logical cluster %s", downstream.GetName(), logicalCluster)
+			continue
+		}
+		if err := c.deleteDownstreamNamespace(ctx, logicalCluster, namespace); err != nil {
+			klog.Errorf("Deleting orphaned downstream namespace %s: %v", downstream.GetName(), err)
+		}
+	}
+}
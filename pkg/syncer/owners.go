@@ -0,0 +1,107 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+const (
+	// OwnerReferencesAnnotation records, on downstream objects, the owner references of the upstream object
+	// which were removed because their owner is not synced to the cluster, as a JSON array.
+	OwnerReferencesAnnotation = "kcp.dev/owner-references"
+
+	// ownerRecheckInterval is how often the spec syncer checks whether the downstream owners of an object are synced.
+	ownerRecheckInterval = 5 * time.Second
+)
+
+// translateOwnerReferences rewrites the owner references of an object transformed for downstream
+// with the UIDs of the downstream owners, since upstream UIDs are meaningless downstream and would make
+// the downstream garbage collector delete the object.
+// References to owners which are not synced to the cluster are removed, and recorded in an annotation.
+//
+// It returns false if an owner synced to the cluster doesn't exist downstream yet: the owner is queued,
+// and the object must be synced once the owner is.
+func (c *Controller) translateOwnerReferences(ctx context.Context, unstrob *unstructured.Unstructured) (bool, error) {
+	logicalCluster := unstrob.GetAnnotations()[LogicalClusterAnnotation]
+	namespace := upstreamNamespace(unstrob)
+
+	synced := true
+	var translated, removed []metav1.OwnerReference
+	for _, reference := range unstrob.GetOwnerReferences() {
+		gvr, ownerNamespace, found := c.ownerGVR(reference, namespace)
+		if !found {
+			removed = append(removed, reference)
+			continue
+		}
+		informer, found := c.getInformer(gvr)
+		if !found {
+			removed = append(removed, reference)
+			continue
+		}
+		owner, err := getFromCache(informer, logicalCluster, ownerNamespace, reference.Name)
+		if err != nil {
+			return false, err
+		}
+		if owner == nil || owner.GetUID() != reference.UID || !c.isAssigned(owner) {
+			removed = append(removed, reference)
+			continue
+		}
+
+		downstreamOwner, err := c.getDownstream(ctx, gvr, c.downstreamNamespace(logicalCluster, ownerNamespace), reference.Name)
+		if err != nil {
+			return false, err
+		}
+		if downstreamOwner == nil {
+			klog.V(2).Infof("Owner %s %s/%s of %s is not synced yet", gvr.Resource, ownerNamespace, reference.Name, unstrob.GetName())
+			c.AddToQueue(gvr, owner)
+			synced = false
+			continue
+		}
+		if !ownedByLogicalCluster(downstreamOwner, logicalCluster) {
+			removed = append(removed, reference)
+			continue
+		}
+		reference.UID = downstreamOwner.GetUID()
+		translated = append(translated, reference)
+	}
+	if !synced {
+		return false, nil
+	}
+
+	unstrob.SetOwnerReferences(translated)
+	if len(removed) > 0 {
+		data, err := json.Marshal(removed)
+		if err != nil {
+			return false, err
+		}
+		annotations := unstrob.GetAnnotations()
+		annotations[OwnerReferencesAnnotation] = string(data)
+		unstrob.SetAnnotations(annotations)
+	}
+	return true, nil
+}
+
+// ownerGVR returns the GVR of the owner of an owner reference, and the namespace of the owner
+// of an object in the given namespace.
+func (c *Controller) ownerGVR(reference metav1.OwnerReference, namespace string) (schema.GroupVersionResource, string, bool) {
+	gv, err := schema.ParseGroupVersion(reference.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, "", false
+	}
+	mapping, err := c.restMapper.RESTMapping(gv.WithKind(reference.Kind).GroupKind(), gv.Version)
+	if err != nil {
+		klog.V(2).Infof("Resolving owner kind %s: %v", reference.Kind, err)
+		return schema.GroupVersionResource{}, "", false
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		namespace = ""
+	}
+	return mapping.Resource, namespace, true
+}
@@ -0,0 +1,97 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+const (
+	// UpstreamUIDAnnotation records, on downstream objects, the UID of the upstream object they were created from.
+	// Along with LogicalClusterAnnotation, it marks the downstream objects owned by the syncer.
+	UpstreamUIDAnnotation = "kcp.dev/upstream-uid"
+
+	// AdoptPolicyNever makes the spec syncer refuse to overwrite downstream objects which were not created by kcp.
+	AdoptPolicyNever = "never"
+	// AdoptPolicyAlways makes the spec syncer take over such downstream objects.
+	// Downstream objects created for another logical cluster are never taken over.
+	AdoptPolicyAlways = "always"
+
+	// ownershipConflictAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+	// which reports on the upstream object that its downstream object exists and is not owned by the syncer.
+	ownershipConflictAnnotationPrefix = "kcp.dev/ownership-conflict."
+
+	// ownershipConflictRecheckInterval is how often the spec syncer checks whether an ownership conflict is resolved.
+	ownershipConflictRecheckInterval = time.Minute
+)
+
+// validateAdoptPolicy returns an error if the adopt policy is unknown.
+func validateAdoptPolicy(adoptPolicy string) error {
+	switch adoptPolicy {
+	case "", AdoptPolicyNever, AdoptPolicyAlways:
+		return nil
+	default:
+		return fmt.Errorf("unknown adopt policy %q", adoptPolicy)
+	}
+}
+
+// ownedByLogicalCluster returns whether the downstream object may be updated or deleted
+// by the syncer on behalf of the logical cluster.
+// Namespaced downstream objects created by syncers which didn't record the logical cluster are recognized
+// by their cluster label. Cluster-scoped downstream objects are shared by all the logical clusters synced to
+// the physical cluster, so they must have been created for this logical cluster.
+func ownedByLogicalCluster(downstream *unstructured.Unstructured, logicalCluster string) bool {
+	owner, found := downstream.GetAnnotations()[LogicalClusterAnnotation]
+	if !found {
+		return downstream.GetNamespace() != "" && downstream.GetLabels()["kcp.dev/cluster"] != ""
+	}
+	return owner == logicalCluster
+}
+
+// checkOwnership returns an error if the existing downstream object must not be overwritten
+// on behalf of the logical cluster, according to the adopt policy.
+func (c *Controller) checkOwnership(existing *unstructured.Unstructured, logicalCluster string) error {
+	if ownedByLogicalCluster(existing, logicalCluster) {
+		return nil
+	}
+	owner, found := existing.GetAnnotations()[LogicalClusterAnnotation]
+	if found {
+		return fmt.Errorf("%s %q already exists on cluster %s and was created for logical cluster %q", existing.GetKind(), existing.GetName(), c.clusterID, owner)
+	}
+	if existing.GetLabels()["kcp.dev/cluster"] != "" {
+		// Cluster-scoped, and possibly created for another logical cluster.
+		return fmt.Errorf("%s %q already exists on cluster %s and was created by kcp without recording its logical cluster", existing.GetKind(), existing.GetName(), c.clusterID)
+	}
+	if c.adoptPolicy == AdoptPolicyAlways {
+		klog.Infof("Adopting %s %s/%s, which was not created by kcp", existing.GetKind(), existing.GetNamespace(), existing.GetName())
+		return nil
+	}
+	return fmt.Errorf("%s %q already exists on cluster %s and was not created by kcp", existing.GetKind(), existing.GetName(), c.clusterID)
+}
+
+// reportOwnershipConflict records the ownership conflict on the upstream object, and checks it again later,
+// since it is only resolved by deleting or relabeling the downstream object.
+func (c *Controller) reportOwnershipConflict(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured, conflict error) {
+	klog.Errorf("Ownership conflict syncing %s %s/%s: %v", gvr.Resource, upstreamObj.GetNamespace(), upstreamObj.GetName(), conflict)
+	c.recordFailure(syncKey(gvr, upstreamObj), conflict)
+	if err := c.setOwnershipConflict(ctx, gvr, upstreamObj, conflict.Error()); err != nil {
+		klog.Errorf("Reporting ownership conflict on upstream resource %s/%s: %v", upstreamObj.GetNamespace(), upstreamObj.GetName(), err)
+	}
+	// In once mode, the conflict is already reported as a failure.
+	if !c.once {
+		c.queue.AddAfter(syncKey(gvr, upstreamObj), ownershipConflictRecheckInterval)
+	}
+}
+
+// setOwnershipConflict records the ownership conflict in an annotation on the upstream object,
+// or removes the annotation if the message is empty. Conflicts of objects synced as dependencies are only logged.
+func (c *Controller) setOwnershipConflict(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured, message string) error {
+	if syncedAsDependency(upstreamObj) {
+		return nil
+	}
+	return c.setFromAnnotation(ctx, gvr, upstreamObj, ownershipConflictAnnotationPrefix+c.clusterID, message)
+}
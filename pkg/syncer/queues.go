@@ -0,0 +1,210 @@
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// resourceQueues is a set of rate-limited queues, one per GVR, each with its own workers,
+// so that a burst of objects of one resource type doesn't delay the sync of the other types.
+// Queue items are routed to the queue of their GVR.
+type resourceQueues struct {
+	// cluster and direction label the metrics of the queues.
+	cluster   string
+	direction string
+
+	lock           sync.Mutex
+	queues         map[schema.GroupVersionResource]workqueue.RateLimitingInterface
+	limiters       map[schema.GroupVersionResource]workqueue.RateLimiter
+	workers        map[string]int
+	defaultWorkers int
+	startWorker    func(queue workqueue.RateLimitingInterface)
+	shutDown       bool
+
+	// delayed is the number of items waiting to be added to the queues, and processing the number of items
+	// being processed by the workers, which the queues don't tell.
+	delayed    int64
+	processing int64
+}
+
+func newResourceQueues(cluster, direction string, workers map[string]int) *resourceQueues {
+	return &resourceQueues{
+		cluster:   cluster,
+		direction: direction,
+		queues:    map[schema.GroupVersionResource]workqueue.RateLimitingInterface{},
+		limiters:  map[schema.GroupVersionResource]workqueue.RateLimiter{},
+		workers:   workers,
+	}
+}
+
+// start starts the workers of the existing queues, and of the queues created afterwards.
+// Each queue gets the number of workers configured for its resource type, or defaultWorkers.
+func (q *resourceQueues) start(defaultWorkers int, startWorker func(queue workqueue.RateLimitingInterface)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.defaultWorkers = defaultWorkers
+	q.startWorker = startWorker
+	for gvr, queue := range q.queues {
+		q.startWorkers(gvr, queue)
+	}
+}
+
+// startWorkers must be called with the lock held.
+func (q *resourceQueues) startWorkers(gvr schema.GroupVersionResource, queue workqueue.RateLimitingInterface) {
+	workers, found := q.workers[gvr.GroupResource().String()]
+	if !found {
+		workers, found = q.workers[gvr.Resource]
+	}
+	if !found {
+		workers = q.defaultWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go q.startWorker(queue)
+	}
+}
+
+// queueFor returns the queue of the GVR of the item, creating it if needed.
+func (q *resourceQueues) queueFor(item interface{}) workqueue.RateLimitingInterface {
+	queue, _ := q.queueAndLimiterFor(item)
+	return queue
+}
+
+// queueAndLimiterFor returns the queue of the GVR of the item, and its rate limiter, creating them if needed.
+func (q *resourceQueues) queueAndLimiterFor(item interface{}) (workqueue.RateLimitingInterface, workqueue.RateLimiter) {
+	gvr := queueKeyGVR(item)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	queue, found := q.queues[gvr]
+	if found {
+		return queue, q.limiters[gvr]
+	}
+	limiter := workqueue.DefaultControllerRateLimiter()
+	// The queue is not named, since the series of the workqueue metrics can't be deleted once the queue is shut down:
+	// the queue metrics of the syncer are recorded instead.
+	queue = workqueue.NewRateLimitingQueue(limiter)
+	if q.shutDown {
+		queue.ShutDown()
+	}
+	q.queues[gvr] = queue
+	q.limiters[gvr] = limiter
+	if q.startWorker != nil {
+		q.startWorkers(gvr, queue)
+	}
+	return queue, limiter
+}
+
+func (q *resourceQueues) Add(item interface{}) {
+	queue := q.queueFor(item)
+	queue.Add(item)
+	q.observeAdd(item, queue)
+}
+
+// AddAfter adds the item to its queue after the duration. Unlike the queue, it keeps track of the delayed items.
+func (q *resourceQueues) AddAfter(item interface{}, duration time.Duration) {
+	queue := q.queueFor(item)
+	if duration <= 0 {
+		queue.Add(item)
+		q.observeAdd(item, queue)
+		return
+	}
+	atomic.AddInt64(&q.delayed, 1)
+	time.AfterFunc(duration, func() {
+		queue.Add(item)
+		q.observeAdd(item, queue)
+		atomic.AddInt64(&q.delayed, -1)
+	})
+}
+
+func (q *resourceQueues) AddRateLimited(item interface{}) {
+	_, limiter := q.queueAndLimiterFor(item)
+	q.AddAfter(item, limiter.When(item))
+}
+
+func (q *resourceQueues) Forget(item interface{}) {
+	q.queueFor(item).Forget(item)
+}
+
+func (q *resourceQueues) NumRequeues(item interface{}) int {
+	return q.queueFor(item).NumRequeues(item)
+}
+
+// Len returns the number of items in all the queues.
+func (q *resourceQueues) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	total := 0
+	for _, queue := range q.queues {
+		total += queue.Len()
+	}
+	return total
+}
+
+// begin and end track the items being processed by the workers.
+func (q *resourceQueues) begin() { atomic.AddInt64(&q.processing, 1) }
+func (q *resourceQueues) end()   { atomic.AddInt64(&q.processing, -1) }
+
+// idle returns whether no item is queued, delayed or being processed.
+func (q *resourceQueues) idle() bool {
+	return q.Len() == 0 && atomic.LoadInt64(&q.delayed) == 0 && atomic.LoadInt64(&q.processing) == 0
+}
+
+func (q *resourceQueues) ShutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.shutDown = true
+	for _, queue := range q.queues {
+		queue.ShutDown()
+	}
+}
+
+// gvrs returns the GVRs of the existing queues.
+func (q *resourceQueues) gvrs() []schema.GroupVersionResource {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var gvrs []schema.GroupVersionResource
+	for gvr := range q.queues {
+		gvrs = append(gvrs, gvr)
+	}
+	return gvrs
+}
+
+func (q *resourceQueues) ShuttingDown() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.shutDown
+}
+
+// ParseResourceWorkers parses a comma-separated list of resource=workers pairs,
+// e.g. deployments.apps=4,configmaps=1.
+func ParseResourceWorkers(s string) (map[string]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	workers := map[string]int{}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid resource workers %q: expected resource=workers", pair)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid resource workers %q: workers must be a positive integer", pair)
+		}
+		workers[parts[0]] = count
+	}
+	return workers, nil
+}
@@ -0,0 +1,70 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseResourceWorkers(t *testing.T) {
+	workers, err := ParseResourceWorkers("deployments.apps=4,configmaps=1")
+	if err != nil {
+		t.Fatalf("ParseResourceWorkers() = %v", err)
+	}
+	if want := map[string]int{"deployments.apps": 4, "configmaps": 1}; !reflect.DeepEqual(workers, want) {
+		t.Errorf("ParseResourceWorkers() = %v, want %v", workers, want)
+	}
+
+	for _, invalid := range []string{"deployments.apps", "deployments.apps=0", "deployments.apps=x"} {
+		if _, err := ParseResourceWorkers(invalid); err == nil {
+			t.Errorf("ParseResourceWorkers(%q) succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestResourceQueues(t *testing.T) {
+	q := newResourceQueues("test", specDirection, nil)
+	defer q.ShutDown()
+
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	for i := 0; i < 100; i++ {
+		q.Add(holder{gvr: configmaps, obj: i})
+	}
+	q.Add(holder{gvr: deployments, obj: 0})
+
+	if got := q.Len(); got != 101 {
+		t.Errorf("Len() = %d, want 101", got)
+	}
+	// The deployment is next in its own queue, regardless of the queued configmaps.
+	if item, _ := q.queueFor(holder{gvr: deployments}).Get(); item != (holder{gvr: deployments, obj: 0}) {
+		t.Errorf("Get() = %v, want the deployment", item)
+	}
+}
+
+func TestResourceQueuesIdle(t *testing.T) {
+	q := newResourceQueues("test", specDirection, nil)
+	defer q.ShutDown()
+
+	if !q.idle() {
+		t.Fatalf("idle() = false for empty queues")
+	}
+	item := holder{gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, obj: 0}
+	q.AddAfter(item, 10*time.Millisecond)
+	if q.idle() {
+		t.Errorf("idle() = true with a delayed item")
+	}
+	queue := q.queueFor(item)
+	got, _ := queue.Get()
+	q.begin()
+	if q.idle() {
+		t.Errorf("idle() = true with an item being processed")
+	}
+	queue.Done(got)
+	q.end()
+	if !q.idle() {
+		t.Errorf("idle() = false once the item is processed")
+	}
+}
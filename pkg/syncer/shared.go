@@ -0,0 +1,213 @@
+package syncer
+
+import (
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// MultiSyncer syncs an upstream logical cluster to several downstream clusters.
+// The spec syncers of the clusters share the informers of all the upstream objects of each type,
+// so that these objects are watched and cached once, and each cluster queues the objects assigned to it
+// from the events of the shared informers.
+type MultiSyncer struct {
+	upstream         *rest.Config
+	numSyncerThreads int
+	opts             Options
+	informers        *sharedInformers
+
+	lock    sync.Mutex
+	syncers map[string]*Syncer
+}
+
+// NewMultiSyncer returns a MultiSyncer syncing the upstream logical cluster with the given options.
+func NewMultiSyncer(upstream *rest.Config, numSyncerThreads int, opts Options) (*MultiSyncer, error) {
+	client, err := dynamic.NewForConfig(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiSyncer{
+		upstream:         upstream,
+		numSyncerThreads: numSyncerThreads,
+		opts:             opts,
+		informers: &sharedInformers{
+			client:    client,
+			informers: map[schema.GroupVersionResource]*sharedInformer{},
+		},
+		syncers: map[string]*Syncer{},
+	}, nil
+}
+
+// StartSyncer starts syncing the resources to a downstream cluster, replacing its previous syncer if any.
+func (m *MultiSyncer) StartSyncer(downstream *rest.Config, resources sets.String, cluster string) (*Syncer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if previous, found := m.syncers[cluster]; found {
+		previous.Stop()
+		delete(m.syncers, cluster)
+	}
+	opts := m.opts
+	opts.sharedInformers = m.informers
+	s, err := StartSyncer(m.upstream, downstream, resources, cluster, m.numSyncerThreads, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.syncers[cluster] = s
+	return s, nil
+}
+
+// StopSyncer stops syncing to a downstream cluster, and returns the number of clusters still synced.
+// The shared informers keep running: call Stop once no cluster is synced anymore.
+func (m *MultiSyncer) StopSyncer(cluster string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if s, found := m.syncers[cluster]; found {
+		s.Stop()
+		delete(m.syncers, cluster)
+	}
+	return len(m.syncers)
+}
+
+// Stop stops syncing to all the downstream clusters, and stops the shared informers.
+func (m *MultiSyncer) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for cluster, s := range m.syncers {
+		s.Stop()
+		delete(m.syncers, cluster)
+	}
+	m.informers.stop()
+}
+
+// sharedInformers are the informers of all the upstream objects of each GVR, run as long as
+// the spec syncer of at least one cluster handles their events.
+type sharedInformers struct {
+	client    dynamic.Interface
+	lock      sync.Mutex
+	informers map[schema.GroupVersionResource]*sharedInformer
+}
+
+// sharedInformer is an informer fanning out its events to the handlers of several spec syncers.
+// Handlers can't be removed from a SharedIndexInformer, so it has a single handler which calls them.
+// They are called synchronously, so they must only queue work.
+type sharedInformer struct {
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+
+	lock     sync.RWMutex
+	handlers map[*cache.ResourceEventHandler]bool
+}
+
+// subscribe returns the running informer of the GVR, starting it if needed, and calls the handler
+// with its events until unsubscribe is called. The handler is first called with the objects
+// the informer already has.
+func (s *sharedInformers) subscribe(gvr schema.GroupVersionResource, handler cache.ResourceEventHandler) (informer cache.SharedIndexInformer, unsubscribe func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	si, found := s.informers[gvr]
+	if !found {
+		si = &sharedInformer{
+			informer: dynamicinformer.NewFilteredDynamicInformer(s.client, gvr, metav1.NamespaceAll, resyncPeriod, cache.Indexers{}, nil).Informer(),
+			stopCh:   make(chan struct{}),
+			handlers: map[*cache.ResourceEventHandler]bool{},
+		}
+		si.informer.AddEventHandler(si)
+		s.informers[gvr] = si
+		go si.informer.Run(si.stopCh)
+
+		klog.Infof("Set up shared informer for %v", gvr)
+	}
+
+	key := &handler
+	si.lock.Lock()
+	si.handlers[key] = true
+	for _, obj := range si.informer.GetStore().List() {
+		handler.OnAdd(obj)
+	}
+	si.lock.Unlock()
+
+	return si.informer, func() { s.unsubscribe(gvr, si, key) }
+}
+
+// unsubscribe stops calling the handler, and stops the informer once it has no handler left.
+func (s *sharedInformers) unsubscribe(gvr schema.GroupVersionResource, si *sharedInformer, key *cache.ResourceEventHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	si.lock.Lock()
+	delete(si.handlers, key)
+	remaining := len(si.handlers)
+	si.lock.Unlock()
+
+	if remaining == 0 && s.informers[gvr] == si {
+		close(si.stopCh)
+		delete(s.informers, gvr)
+
+		klog.Infof("Stopped shared informer for %v", gvr)
+	}
+}
+
+// stop stops all the informers, without waiting for their handlers to be unsubscribed.
+func (s *sharedInformers) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for gvr, si := range s.informers {
+		close(si.stopCh)
+		delete(s.informers, gvr)
+	}
+}
+
+func (si *sharedInformer) OnAdd(obj interface{}) {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+	for handler := range si.handlers {
+		(*handler).OnAdd(obj)
+	}
+}
+
+func (si *sharedInformer) OnUpdate(oldObj, newObj interface{}) {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+	for handler := range si.handlers {
+		(*handler).OnUpdate(oldObj, newObj)
+	}
+}
+
+func (si *sharedInformer) OnDelete(obj interface{}) {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+	for handler := range si.handlers {
+		(*handler).OnDelete(obj)
+	}
+}
+
+// runUpstreamInformer returns a running informer of all the upstream objects of the GVR,
+// calling the handler with its events until stopCh is closed.
+// It is shared with the spec syncers of the other clusters, when syncing several clusters.
+func (c *Controller) runUpstreamInformer(gvr schema.GroupVersionResource, handler cache.ResourceEventHandler, stopCh <-chan struct{}) cache.SharedIndexInformer {
+	if c.sharedInformers != nil {
+		informer, unsubscribe := c.sharedInformers.subscribe(gvr, handler)
+		go func() {
+			<-stopCh
+			unsubscribe()
+		}()
+		return informer
+	}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(c.fromClient, gvr, metav1.NamespaceAll, resyncPeriod, cache.Indexers{}, nil).Informer()
+	informer.AddEventHandler(handler)
+	go informer.Run(stopCh)
+	return informer
+}
@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSharedInformers(t *testing.T) {
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	configmap := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "config", "namespace": "ns"},
+	}}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{configmaps: "ConfigMapList"}, configmap)
+	s := &sharedInformers{client: client, informers: map[schema.GroupVersionResource]*sharedInformer{}}
+
+	var first, second int32
+	counter := func(added *int32) cache.ResourceEventHandler {
+		return cache.ResourceEventHandlerFuncs{AddFunc: func(interface{}) { atomic.AddInt32(added, 1) }}
+	}
+	informer, unsubscribeFirst := s.subscribe(configmaps, counter(&first))
+	if !cache.WaitForCacheSync(wait.NeverStop, informer.HasSynced) {
+		t.Fatal("informer not synced")
+	}
+	// The second handler gets the objects already cached by the shared informer.
+	if shared, unsubscribeSecond := s.subscribe(configmaps, counter(&second)); shared != informer {
+		t.Errorf("subscribe() started a second informer")
+	} else {
+		defer unsubscribeSecond()
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return atomic.LoadInt32(&first) == 1 && atomic.LoadInt32(&second) == 1, nil
+	}); err != nil {
+		t.Errorf("handlers got %d and %d adds, want 1 each", first, second)
+	}
+
+	unsubscribeFirst()
+	if len(s.informers) != 1 {
+		t.Errorf("informer stopped while a handler is subscribed")
+	}
+}
@@ -2,30 +2,64 @@ package syncer
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
 
+// applyConflictAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+// which reports server-side apply conflicts on the upstream object.
+const applyConflictAnnotationPrefix = "kcp.dev/apply-conflict."
+
+// isSyncerAnnotation returns whether the annotation is written by the syncers on upstream objects
+// to report on the sync. Such annotations are meaningless downstream.
+func isSyncerAnnotation(key string) bool {
+	return strings.HasPrefix(key, applyConflictAnnotationPrefix) ||
+		strings.HasPrefix(key, driftAnnotationPrefix) ||
+		strings.HasPrefix(key, syncStatusAnnotationPrefix) ||
+		strings.HasPrefix(key, locationStatusAnnotationPrefix) ||
+		strings.HasPrefix(key, ownershipConflictAnnotationPrefix) ||
+		strings.HasPrefix(key, syncedSpecAnnotationPrefix)
+}
+
+// withoutSyncerAnnotations returns the annotations, apart from the ones written by the syncers.
+func withoutSyncerAnnotations(annotations map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key, value := range annotations {
+		if !isSyncerAnnotation(key) {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
 func deepEqualApartFromStatus(oldObj, newObj interface{}) bool {
 	oldUnstrob, isOldObjUnstructured := oldObj.(*unstructured.Unstructured)
 	newUnstrob, isNewObjUnstructured := newObj.(*unstructured.Unstructured)
 	if !isOldObjUnstructured || !isNewObjUnstructured {
 		return false
 	}
-	if !equality.Semantic.DeepEqual(oldUnstrob.GetAnnotations(), newUnstrob.GetAnnotations()) {
+	if !equality.Semantic.DeepEqual(withoutSyncerAnnotations(oldUnstrob.GetAnnotations()), withoutSyncerAnnotations(newUnstrob.GetAnnotations())) {
 		return false
 	}
 	if !equality.Semantic.DeepEqual(oldUnstrob.GetLabels(), newUnstrob.GetLabels()) {
 		return false
 	}
+	if !equality.Semantic.DeepEqual(oldUnstrob.GetDeletionTimestamp(), newUnstrob.GetDeletionTimestamp()) {
+		return false
+	}
 
 	oldObjKeys := sets.StringKeySet(oldUnstrob.UnstructuredContent())
 	newObjKeys := sets.StringKeySet(newUnstrob.UnstructuredContent())
@@ -40,73 +74,180 @@ func deepEqualApartFromStatus(oldObj, newObj interface{}) bool {
 	return true
 }
 
-func NewSpecSyncer(from, to *rest.Config, syncedResourceTypes []string, clusterID string) (*Controller, error) {
-	return New(from, to, upsertIntoDownstream, deleteFromDownstream, func(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs {
+func NewSpecSyncer(from, to *rest.Config, syncedResourceTypes []string, clusterID string, opts Options) (*Controller, error) {
+	if err := validateDriftPolicy(opts.DriftPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateOrphanPolicy(opts.OrphanPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateAdoptPolicy(opts.AdoptPolicy); err != nil {
+		return nil, err
+	}
+
+	upsertFn := upsertIntoDownstream
+	if opts.ServerSideApply {
+		upsertFn = applyIntoDownstream
+	}
+	syncDependencyFn := upsertFn
+	upsertFn, deleteFn := withDeletionFinalizer(upsertFn), DeleteFunc(deleteFromDownstream)
+	if opts.SyncDependencies {
+		upsertFn, deleteFn = withDependencySync(upsertFn, deleteFn)
+	}
+	upsertFn, deleteFn = withNamespaceSync(withAssignment(upsertFn, deleteFn))
+	c, err := New(from, to, upsertFn, deleteFn, func(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs {
+		// Upstream objects are watched whether they are assigned to the cluster or not, apart from namespaces.
+		// Objects which are not assigned anymore are queued, to be deleted downstream.
+		handled := func(obj interface{}) bool {
+			return gvr == namespacesGVR || c.isAssigned(obj)
+		}
 		return cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) { c.AddToQueue(gvr, obj) },
+			AddFunc: func(obj interface{}) {
+				if handled(obj) {
+					c.AddToQueue(gvr, obj)
+				}
+			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				if !deepEqualApartFromStatus(oldObj, newObj) {
+				if (handled(oldObj) || handled(newObj)) && !deepEqualApartFromStatus(oldObj, newObj) {
 					c.AddToQueue(gvr, newObj)
 				}
 			},
-			DeleteFunc: func(obj interface{}) { c.AddToQueue(gvr, obj) },
+			DeleteFunc: func(obj interface{}) {
+				if handled(obj) {
+					c.AddToQueue(gvr, obj)
+				}
+			},
 		}
-	}, syncedResourceTypes, clusterID)
-}
+	}, syncedResourceTypes, clusterID, specDirection, opts)
+	if err != nil {
+		return nil, err
+	}
 
-// TODO:
-// This function is there as a quick and dirty implementation of namespace creation.
-// In fact We should also be getting notifications about namespaces created upstream and be creating downstream equivalents.
-func (c *Controller) ensureNamespaceExists(namespace string) error {
-	namespaces := c.toClient.Resource(schema.GroupVersionResource{
-		Group:    "",
-		Version:  "v1",
-		Resource: "namespaces",
-	})
-	newNamespace := &unstructured.Unstructured{}
-	newNamespace.SetAPIVersion("v1")
-	newNamespace.SetKind("Namespace")
-	newNamespace.SetName(namespace)
-	if _, err := namespaces.Create(context.TODO(), newNamespace, metav1.CreateOptions{}); err != nil {
-		if !k8serrors.IsAlreadyExists(err) {
-			klog.Infof("Error while creating namespace %s: %v", namespace, err)
-			return err
-		}
+	// Conditions are written on the status of upstream objects.
+	c.statusSubresources = &statusSubresources{discovery: c.fromDiscovery, found: map[schema.GroupVersionResource]bool{}}
+
+	// Watch all upstream namespaces, to propagate their labels, annotations and deletion downstream.
+	c.addPinnedInformer(namespacesGVR, nil)
+
+	if opts.SyncDependencies {
+		c.watchDependencies(syncDependencyFn)
 	}
-	return nil
-}
 
-func deleteFromDownstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) error {
-	// TODO: get UID of just-deleted object and pass it as a precondition on this delete.
-	// This would avoid races where an object is deleted and another object with the same name is created immediately after.
+	// Watch the downstream objects, to detect when they drift from their upstream objects.
+	c.watchDownstream(downstreamHandlers)
+
+	// Clean up the downstream objects whose upstream deletion was missed while the syncer was down.
+	c.orphansCleanedUp = make(chan struct{})
+	go c.cleanupOrphans(opts.OrphanPolicy)
 
-	return c.getClient(gvr, namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	return c, nil
 }
 
-func upsertIntoDownstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
-	if err := c.ensureNamespaceExists(namespace); err != nil {
-		klog.Error(err)
+func deleteFromDownstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, meta metav1.Object) error {
+	name := meta.GetName()
+	downstreamNamespace := c.downstreamNamespace(meta.GetClusterName(), namespace)
+	if _, err := c.deleteDownstream(ctx, gvr, meta.GetClusterName(), downstreamNamespace, name, meta.GetUID()); err != nil {
+		klog.Errorf("Deleting downstream resource %s/%s: %v", downstreamNamespace, name, err)
 		return err
 	}
 
-	client := c.getClient(gvr, namespace)
+	// The upstream object might still exist, but not be assigned to this cluster anymore:
+	// in such a case, its deletion must not be blocked by the syncer finalizer.
+	upstream, err := c.getFromClient(gvr, namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.removeFinalizer(ctx, gvr, upstream)
+}
 
+// transformForDownstream returns a copy of the upstream object, suitable
+// to be created or applied in the downstream cluster.
+func (c *Controller) transformForDownstream(gvr schema.GroupVersionResource, unstrob *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	logicalCluster, namespace, uid, generation := unstrob.GetClusterName(), unstrob.GetNamespace(), unstrob.GetUID(), unstrob.GetGeneration()
 	unstrob = unstrob.DeepCopy()
 
-	// Attempt to create the object; if the object already exists, update it.
 	unstrob.SetUID("")
 	unstrob.SetResourceVersion("")
+	unstrob.SetNamespace(c.downstreamNamespace(logicalCluster, namespace))
 
-	ownedByLabel := unstrob.GetLabels()["kcp.dev/owned-by"]
-	var ownerReferences []metav1.OwnerReference
-	for _, reference := range unstrob.GetOwnerReferences() {
-		if reference.Name == ownedByLabel {
-			continue
+	// Label the downstream object with the cluster, to find it back even if its upstream object is gone.
+	labels := unstrob.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels["kcp.dev/cluster"] = c.clusterID
+	// The assignment is meaningless downstream.
+	for key := range labels {
+		if strings.HasPrefix(key, LocationLabelPrefix) {
+			delete(labels, key)
+		}
+	}
+	unstrob.SetLabels(labels)
+
+	// Annotations written by the syncers on the upstream object, and the assignment, are meaningless downstream.
+	annotations := unstrob.GetAnnotations()
+	for key := range annotations {
+		if isSyncerAnnotation(key) || key == AssignedLocationsAnnotation {
+			delete(annotations, key)
+		}
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	// Record where the downstream object comes from, which marks it as owned by the syncer.
+	annotations[LogicalClusterAnnotation] = logicalCluster
+	annotations[UpstreamUIDAnnotation] = string(uid)
+	// Record the upstream generation of the spec, to translate the observed generation of the status synced upstream.
+	annotations[UpstreamGenerationAnnotation] = strconv.FormatInt(generation, 10)
+	if namespace != "" {
+		annotations[NamespaceAnnotation] = namespace
+	}
+	unstrob.SetAnnotations(annotations)
+
+	// Syncer finalizers would block the deletion of the downstream object forever.
+	var finalizers []string
+	for _, finalizer := range unstrob.GetFinalizers() {
+		if !strings.HasPrefix(finalizer, syncerFinalizerPrefix) {
+			finalizers = append(finalizers, finalizer)
 		}
-		ownerReferences = append(ownerReferences, reference)
 	}
-	unstrob.SetOwnerReferences(ownerReferences)
+	unstrob.SetFinalizers(finalizers)
+
+	if err := c.transformToDownstream(gvr, unstrob); err != nil {
+		return nil, err
+	}
+	return unstrob, nil
+}
 
+func upsertIntoDownstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, upstreamObj *unstructured.Unstructured) error {
+	if err := c.ensureNamespaceExists(ctx, upstreamObj.GetClusterName(), namespace); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	unstrob, err := c.transformForDownstream(gvr, upstreamObj)
+	if err != nil {
+		klog.Errorf("Transforming resource %s/%s: %v", namespace, upstreamObj.GetName(), err)
+		return err
+	}
+
+	synced, err := c.translateOwnerReferences(ctx, unstrob)
+	if err != nil {
+		klog.Errorf("Translating owner references of resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		return err
+	}
+	if !synced {
+		c.recheckLater(syncKey(gvr, upstreamObj), ownerRecheckInterval, "owners not synced yet")
+		return nil
+	}
+	namespace = unstrob.GetNamespace()
+
+	client := c.getClient(gvr, namespace)
+
+	// Attempt to create the object; if the object already exists, update it.
 	if _, err := client.Create(ctx, unstrob, metav1.CreateOptions{}); err != nil {
 		if !k8serrors.IsAlreadyExists(err) {
 			klog.Errorf("Creating resource %s/%s: %v", namespace, unstrob.GetName(), err)
@@ -118,6 +259,10 @@ func upsertIntoDownstream(c *Controller, ctx context.Context, gvr schema.GroupVe
 			klog.Errorf("Getting resource %s/%s: %v", namespace, unstrob.GetName(), err)
 			return err
 		}
+		if err := c.checkOwnership(existing, upstreamObj.GetClusterName()); err != nil {
+			c.reportOwnershipConflict(ctx, gvr, upstreamObj, err)
+			return nil
+		}
 		klog.Infof("Object %s/%s already exists: update it", gvr.Resource, unstrob.GetName())
 
 		unstrob.SetResourceVersion(existing.GetResourceVersion())
@@ -125,8 +270,132 @@ func upsertIntoDownstream(c *Controller, ctx context.Context, gvr schema.GroupVe
 			klog.Errorf("Updating resource %s/%s: %v", namespace, unstrob.GetName(), err)
 			return err
 		}
+	} else {
+		klog.Infof("Created object %s/%s", gvr.Resource, unstrob.GetName())
+	}
+
+	if err := c.setOwnershipConflict(ctx, gvr, upstreamObj, ""); err != nil {
+		return err
+	}
+	return c.recordSyncedSpec(ctx, gvr, upstreamObj)
+}
+
+// applyIntoDownstream patches the downstream object with server-side apply, so that
+// fields managed downstream by other actors (autoscalers, webhooks, defaulters) are preserved.
+// Field ownership conflicts are not forced, but recorded in an annotation on the upstream object.
+func applyIntoDownstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, upstreamObj *unstructured.Unstructured) error {
+	if err := c.ensureNamespaceExists(ctx, upstreamObj.GetClusterName(), namespace); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	unstrob, err := c.transformForDownstream(gvr, upstreamObj)
+	if err != nil {
+		klog.Errorf("Transforming resource %s/%s: %v", namespace, upstreamObj.GetName(), err)
+		return err
+	}
+
+	synced, err := c.translateOwnerReferences(ctx, unstrob)
+	if err != nil {
+		klog.Errorf("Translating owner references of resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		return err
+	}
+	if !synced {
+		c.recheckLater(syncKey(gvr, upstreamObj), ownerRecheckInterval, "owners not synced yet")
 		return nil
 	}
-	klog.Infof("Created object %s/%s", gvr.Resource, unstrob.GetName())
-	return nil
+	namespace = unstrob.GetNamespace()
+
+	unstrob.SetManagedFields(nil)
+	unstrob.SetCreationTimestamp(metav1.Time{})
+	unstrob.SetGeneration(0)
+	unstrob.SetSelfLink("")
+	delete(unstrob.Object, "status")
+
+	// Server-side apply would merge into a downstream object which is not owned by the syncer.
+	existing, err := c.getAnyDownstream(ctx, gvr, namespace, unstrob.GetName())
+	if err != nil {
+		klog.Errorf("Getting resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		return err
+	}
+	if existing != nil {
+		if err := c.checkOwnership(existing, upstreamObj.GetClusterName()); err != nil {
+			c.reportOwnershipConflict(ctx, gvr, upstreamObj, err)
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(unstrob)
+	if err != nil {
+		return err
+	}
+
+	force := false
+	if _, err := c.getClient(gvr, namespace).Patch(ctx, unstrob.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: c.fieldManager(),
+		Force:        &force,
+	}); err != nil {
+		if k8serrors.IsConflict(err) {
+			klog.Errorf("Conflict applying resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			if err := c.setApplyConflict(ctx, gvr, upstreamObj, err.Error()); err != nil {
+				klog.Errorf("Reporting apply conflict on upstream resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			}
+			return err
+		}
+		klog.Errorf("Applying resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		return err
+	}
+	klog.Infof("Applied object %s/%s", gvr.Resource, unstrob.GetName())
+
+	if err := c.setOwnershipConflict(ctx, gvr, upstreamObj, ""); err != nil {
+		return err
+	}
+	if err := c.setApplyConflict(ctx, gvr, upstreamObj, ""); err != nil {
+		return err
+	}
+	return c.recordSyncedSpec(ctx, gvr, upstreamObj)
+}
+
+// fieldManager returns the server-side apply field manager of the syncer for this cluster.
+func (c *Controller) fieldManager() string {
+	return "kcp-syncer-" + c.clusterID
+}
+
+// setApplyConflict records the message of a server-side apply conflict in an annotation
+// on the upstream object, or removes the annotation if the message is empty.
+func (c *Controller) setApplyConflict(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured, message string) error {
+	if syncedAsDependency(upstreamObj) {
+		return nil
+	}
+	return c.setFromAnnotation(ctx, gvr, upstreamObj, applyConflictAnnotationPrefix+c.clusterID, message)
+}
+
+// setFromAnnotation sets an annotation on the object on the "from" side,
+// or removes the annotation if the message is empty.
+func (c *Controller) setFromAnnotation(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured, key, message string) error {
+	return setAnnotation(ctx, c.getFromClient(gvr, upstreamObj.GetNamespace()), upstreamObj, key, message)
+}
+
+// setAnnotation sets an annotation on the object with a merge patch, or removes the annotation if the message is empty.
+func setAnnotation(ctx context.Context, client dynamic.ResourceInterface, obj *unstructured.Unstructured, key, message string) error {
+	if obj.GetAnnotations()[key] == message {
+		return nil
+	}
+
+	var value interface{}
+	if message != "" {
+		value = message
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				key: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Patch(ctx, obj.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
 }
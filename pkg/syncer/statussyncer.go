@@ -2,13 +2,23 @@ package syncer
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 )
 
@@ -26,38 +36,258 @@ func deepEqualStatus(oldObj, newObj interface{}) bool {
 	return false
 }
 
-func NewStatusSyncer(from, to *rest.Config, syncedResourceTypes []string, clusterID string) (*Controller, error) {
-	return New(from, to, updateStatusInUpstream, nil, func(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs {
+// UpstreamGenerationAnnotation records, on downstream objects, the generation of the upstream object they were synced from.
+const UpstreamGenerationAnnotation = "kcp.dev/upstream-generation"
+
+// rewriteObservedGeneration rewrites the observed generations in the status of a downstream object, which refer to
+// the generation of the downstream object, to the matching upstream generation, so that rollouts can be followed upstream.
+// The downstream object reflects the upstream generation in its annotation once it observed its own generation.
+// Until then, the observed generations of the previous upstream status are kept, since the upstream generation
+// the downstream object observed is unknown.
+func rewriteObservedGeneration(obj *unstructured.Unstructured, previousStatus map[string]interface{}) error {
+	value, found := obj.GetAnnotations()[UpstreamGenerationAnnotation]
+	if !found {
+		return nil
+	}
+	upstreamGeneration, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation %q: %w", UpstreamGenerationAnnotation, value, err)
+	}
+	// rewrite sets the observed generation in the fields, or removes it if it was not reported upstream yet.
+	rewrite := func(fields map[string]interface{}, observedGeneration int64, previous map[string]interface{}) {
+		if observedGeneration >= obj.GetGeneration() {
+			fields["observedGeneration"] = upstreamGeneration
+		} else if previousGeneration, found := int64Value(previous["observedGeneration"]); found {
+			fields["observedGeneration"] = previousGeneration
+		} else {
+			delete(fields, "observedGeneration")
+		}
+	}
+
+	status, found, _ := unstructured.NestedMap(obj.Object, "status")
+	if !found {
+		return nil
+	}
+	if observedGeneration, found := int64Value(status["observedGeneration"]); found {
+		rewrite(status, observedGeneration, previousStatus)
+	}
+	if conditions, isSlice := status["conditions"].([]interface{}); isSlice {
+		previousConditions := map[string]map[string]interface{}{}
+		if previous, isSlice := previousStatus["conditions"].([]interface{}); isSlice {
+			for _, condition := range previous {
+				if condition, isMap := condition.(map[string]interface{}); isMap {
+					if conditionType, isString := condition["type"].(string); isString {
+						previousConditions[conditionType] = condition
+					}
+				}
+			}
+		}
+		for _, condition := range conditions {
+			condition, isMap := condition.(map[string]interface{})
+			if !isMap {
+				continue
+			}
+			if observedGeneration, found := int64Value(condition["observedGeneration"]); found {
+				conditionType, _ := condition["type"].(string)
+				rewrite(condition, observedGeneration, previousConditions[conditionType])
+			}
+		}
+	}
+	return unstructured.SetNestedMap(obj.Object, status, "status")
+}
+
+// int64Value returns the value of an integer field, decoded from JSON or not.
+func int64Value(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return value, true
+	case float64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+func NewStatusSyncer(from, to *rest.Config, syncedResourceTypes []string, clusterID string, opts Options) (*Controller, error) {
+	c, err := New(from, to, withEventSync(withNamespaceStatus(updateStatusInUpstream)), nil, func(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs {
+		if gvr == eventsGVR {
+			return eventHandlers(c)
+		}
 		return cache.ResourceEventHandlerFuncs{
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				if !deepEqualStatus(oldObj, newObj) {
+				if !deepEqualStatus(oldObj, newObj) || upstreamGenerationChanged(oldObj, newObj) {
 					c.AddToQueue(gvr, newObj)
 				}
 			},
 		}
-	}, syncedResourceTypes, clusterID)
+	}, syncedResourceTypes, clusterID, statusDirection, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	toDiscovery, err := discovery.NewDiscoveryClientForConfig(to)
+	if err != nil {
+		c.Stop()
+		return nil, err
+	}
+	c.statusSubresources = &statusSubresources{discovery: toDiscovery, found: map[schema.GroupVersionResource]bool{}}
+
+	// Watch the downstream namespaces created by the spec syncer, to reflect their status upstream.
+	c.addPinnedInformer(namespacesGVR, c.clusterLabelSelector)
+
+	if opts.SyncEvents {
+		// Watch the downstream Events, to republish the ones about synced objects upstream.
+		c.addPinnedInformer(eventsGVR, nil)
+	}
+
+	return c, nil
 }
 
 func updateStatusInUpstream(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error {
+	logicalCluster := unstrob.GetAnnotations()[LogicalClusterAnnotation]
+	namespace = upstreamNamespace(unstrob)
 	client := c.getClient(gvr, namespace)
 
 	unstrob = unstrob.DeepCopy()
-
-	// Attempt to create the object; if the object already exists, update it.
 	unstrob.SetUID("")
 	unstrob.SetResourceVersion("")
+	unstrob.SetNamespace(namespace)
+	downstreamObj := unstrob
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := client.Get(ctx, downstreamObj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if logicalCluster != "" && existing.GetClusterName() != logicalCluster {
+			// The downstream object was synced from an object with the same name in another logical cluster.
+			klog.V(2).Infof("Skipping status of resource %s/%s synced from logical cluster %s", namespace, downstreamObj.GetName(), logicalCluster)
+			return nil
+		}
+		// The locations of an upstream object assigned to several clusters would overwrite each other's status.
+		multipleLocations := assignedLocations(existing).Len() > 1
+		previousStatus, _, _ := unstructured.NestedMap(existing.Object, "status")
+		if multipleLocations {
+			previousStatus = c.locationStatus(existing)
+		}
 
-	existing, err := client.Get(ctx, unstrob.GetName(), metav1.GetOptions{})
+		unstrob := downstreamObj.DeepCopy()
+		if err := rewriteObservedGeneration(unstrob, previousStatus); err != nil {
+			klog.Errorf("Rewriting observed generation of resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			return err
+		}
+		if err := c.transformFromDownstream(gvr, unstrob); err != nil {
+			klog.Errorf("Transforming resource %s/%s: %v", namespace, unstrob.GetName(), err)
+			return err
+		}
+		if _, found := unstrob.Object["status"]; !found {
+			// None of the selected status fields is set yet.
+			klog.V(4).Infof("Skipping status of resource %s/%s without selected status fields", namespace, unstrob.GetName())
+			return nil
+		}
+		if multipleLocations {
+			return c.setLocationStatus(ctx, gvr, existing, unstrob)
+		}
+
+		patch, err := statusPatch(existing, unstrob)
+		if err != nil || patch == nil {
+			return err
+		}
+		return c.patchStatus(ctx, gvr, client, unstrob.GetName(), patch)
+	})
 	if err != nil {
-		klog.Errorf("Getting resource %s/%s: %v", namespace, unstrob.GetName(), err)
+		klog.Errorf("Updating status of resource %s/%s: %v", namespace, unstrob.GetName(), err)
 		return err
 	}
+	return nil
+}
 
-	unstrob.SetResourceVersion(existing.GetResourceVersion())
-	if _, err := client.UpdateStatus(ctx, unstrob, metav1.UpdateOptions{}); err != nil {
-		klog.Errorf("Updating status of resource %s/%s: %v", namespace, unstrob.GetName(), err)
-		return err
+// upstreamGenerationChanged returns whether the downstream object was synced from another upstream generation,
+// which changes the observed generation synced upstream even if its status didn't change.
+func upstreamGenerationChanged(oldObj, newObj interface{}) bool {
+	oldMeta, isOldMeta := oldObj.(metav1.Object)
+	newMeta, isNewMeta := newObj.(metav1.Object)
+	if !isOldMeta || !isNewMeta {
+		return false
 	}
+	return oldMeta.GetAnnotations()[UpstreamGenerationAnnotation] != newMeta.GetAnnotations()[UpstreamGenerationAnnotation]
+}
 
-	return nil
+// statusPatch returns the merge patch of the status of the existing object to the desired status,
+// or nil if the status is already the desired one. The patch fails with a conflict if the existing
+// object changed in the meantime.
+func statusPatch(existing, desired *unstructured.Unstructured) ([]byte, error) {
+	original, err := json.Marshal(map[string]interface{}{"status": existing.Object["status"]})
+	if err != nil {
+		return nil, err
+	}
+	modified, err := json.Marshal(map[string]interface{}{"status": desired.Object["status"]})
+	if err != nil {
+		return nil, err
+	}
+	data, err := jsonpatch.CreateMergePatch(original, modified)
+	if err != nil {
+		return nil, err
+	}
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	patch["metadata"] = map[string]interface{}{"resourceVersion": existing.GetResourceVersion()}
+	return json.Marshal(patch)
+}
+
+// patchStatus patches the status of an upstream object with a patch made by statusPatch.
+// Resource types without a status subresource have their status patched on the main resource.
+func (c *Controller) patchStatus(ctx context.Context, gvr schema.GroupVersionResource, client dynamic.ResourceInterface, name string, patch []byte) error {
+	hasStatus, err := c.statusSubresources.has(gvr)
+	if err != nil {
+		return fmt.Errorf("discovering the status subresource of %v: %w", gvr, err)
+	}
+	var subresources []string
+	if hasStatus {
+		subresources = []string{"status"}
+	}
+	_, err = client.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}, subresources...)
+	return err
+}
+
+// statusSubresources discovers which resource types have a status subresource.
+type statusSubresources struct {
+	discovery discovery.DiscoveryInterface
+
+	lock sync.Mutex
+	// found caches whether each GVR has a status subresource.
+	found map[schema.GroupVersionResource]bool
+}
+
+func (s *statusSubresources) has(gvr schema.GroupVersionResource) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if found, cached := s.found[gvr]; cached {
+		return found, nil
+	}
+	resources, err := s.discovery.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource+"/status" {
+			found = true
+		}
+	}
+	s.found[gvr] = found
+	return found, nil
+}
+
+// reset forgets the discovered status subresources, which may have changed along with resource types.
+func (s *statusSubresources) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.found = map[schema.GroupVersionResource]bool{}
 }
@@ -0,0 +1,82 @@
+package syncer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestStatusPatch(t *testing.T) {
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo", "resourceVersion": "42"},
+		"spec":     map[string]interface{}{"replicas": int64(3)},
+		"status":   map[string]interface{}{"replicas": int64(1), "readyReplicas": int64(1)},
+	}}
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"spec":     map[string]interface{}{"replicas": int64(5)},
+		"status":   map[string]interface{}{"replicas": int64(3)},
+	}}
+
+	patch, err := statusPatch(existing, desired)
+	if err != nil {
+		t.Fatalf("statusPatch() = %v", err)
+	}
+	if want := `{"metadata":{"resourceVersion":"42"},"status":{"readyReplicas":null,"replicas":3}}`; string(patch) != want {
+		t.Errorf("statusPatch() = %s, want %s", patch, want)
+	}
+
+	if patch, err := statusPatch(existing, existing); err != nil || patch != nil {
+		t.Errorf("statusPatch() of an unchanged status = %s, %v, want no patch", patch, err)
+	}
+}
+
+func TestRewriteObservedGeneration(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		observedGeneration int64
+		previousStatus     map[string]interface{}
+		want               int64
+		wantFound          bool
+	}{
+		{name: "observed", observedGeneration: 3, want: 7, wantFound: true},
+		{
+			name:               "not observed yet",
+			observedGeneration: 2,
+			previousStatus: map[string]interface{}{
+				"observedGeneration": int64(5),
+				"conditions":         []interface{}{map[string]interface{}{"type": "Ready", "observedGeneration": float64(5)}},
+			},
+			want:      5,
+			wantFound: true,
+		},
+		{name: "not observed yet nor reported", observedGeneration: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name":        "foo",
+					"generation":  int64(3),
+					"annotations": map[string]interface{}{UpstreamGenerationAnnotation: "7"},
+				},
+				"status": map[string]interface{}{
+					"observedGeneration": tc.observedGeneration,
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Ready", "observedGeneration": tc.observedGeneration},
+					},
+				},
+			}}
+			if err := rewriteObservedGeneration(obj, tc.previousStatus); err != nil {
+				t.Fatalf("rewriteObservedGeneration() = %v", err)
+			}
+			if got, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); got != tc.want || found != tc.wantFound {
+				t.Errorf("status.observedGeneration = %d (found: %t), want %d (found: %t)", got, found, tc.want, tc.wantFound)
+			}
+			conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+			got, found := conditions[0].(map[string]interface{})["observedGeneration"]
+			if found != tc.wantFound || (found && got != tc.want) {
+				t.Errorf("status.conditions[0].observedGeneration = %v (found: %t), want %d (found: %t)", got, found, tc.want, tc.wantFound)
+			}
+		})
+	}
+}
@@ -3,20 +3,24 @@ package syncer
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
@@ -25,8 +29,73 @@ import (
 )
 
 const resyncPeriod = 10 * time.Hour
+const discoveryPollInterval = time.Minute
 const SyncerNamespaceKey = "SYNCER_NAMESPACE"
 
+// Options holds the optional behaviors of a Syncer.
+type Options struct {
+	// ServerSideApply makes the spec syncer patch downstream objects with
+	// server-side apply, instead of creating or fully updating them.
+	ServerSideApply bool
+
+	// NamespaceMapping is the name of the mapping of upstream namespaces to downstream namespaces.
+	// See NewNamespaceMapper for the available mappings.
+	NamespaceMapping string
+
+	// ClusterScopedResources is the allowlist of cluster-scoped resource types which may be synced.
+	// Cluster-scoped resource types which are not in this list are ignored.
+	ClusterScopedResources []string
+
+	// Transformations are applied to the objects synced downstream, and reversed on their status synced upstream.
+	Transformations []TransformationSpec
+
+	// DriftPolicy is what the spec syncer does when a downstream object is changed or deleted
+	// directly in the downstream cluster: either DriftPolicyRevert or DriftPolicyReport.
+	// Downstream objects are reverted if it is empty.
+	DriftPolicy string
+
+	// OrphanPolicy is what the spec syncer does on startup with the downstream objects assigned to the cluster
+	// which have no upstream object anymore: either OrphanPolicyDelete or OrphanPolicyReport.
+	// Such downstream objects are deleted if it is empty.
+	OrphanPolicy string
+
+	// LogicalCluster is the logical cluster synced to the cluster. On startup, the spec syncer only cleans up
+	// the orphaned downstream objects and namespaces created for this logical cluster, since the syncers of
+	// other logical clusters may sync to the same cluster.
+	LogicalCluster string
+
+	// AdoptPolicy is what the spec syncer does when a downstream object to create already exists,
+	// and was not created by kcp: either AdoptPolicyNever or AdoptPolicyAlways.
+	// Such downstream objects are left untouched, and the conflict is reported upstream, if it is empty.
+	AdoptPolicy string
+
+	// ResourceWorkers is the number of workers syncing each resource type, e.g. deployments.apps.
+	// Each resource type is synced from its own queue, by the default number of workers if not listed.
+	ResourceWorkers map[string]int
+
+	// SyncDependencies makes the spec syncer also sync the ConfigMaps, Secrets, ServiceAccounts and
+	// PersistentVolumeClaims referenced by the pod templates of the synced workloads, as long as they are referenced.
+	SyncDependencies bool
+
+	// SyncEvents makes the status syncer republish the downstream Events about synced objects
+	// in the logical cluster of the objects.
+	SyncEvents bool
+
+	// DryRun makes the syncers send every write with server-side dry-run, and report the changes
+	// they would have made, with a field-level diff against the live objects, instead.
+	DryRun bool
+	// DryRunOutput is where the changes are written in dry-run mode, as JSON lines. They are logged if it is nil.
+	DryRunOutput io.Writer
+
+	// Once makes the syncers report the objects waiting to be checked again later as blocked,
+	// instead of queueing them again after a delay, so that a one-off reconciliation converges.
+	Once bool
+
+	// sharedInformers, if set, provide the upstream informers of the spec syncer, shared with
+	// the spec syncers of other clusters. It is set by MultiSyncer.
+	sharedInformers *sharedInformers
+}
+
 type Syncer struct {
 	specSyncer   *Controller
 	statusSyncer *Controller
@@ -43,12 +112,20 @@ func (s *Syncer) WaitUntilDone() {
 	<-s.statusSyncer.Done()
 }
 
-func StartSyncer(upstream, downstream *rest.Config, resources sets.String, cluster string, numSyncerThreads int) (*Syncer, error) {
-	specSyncer, err := NewSpecSyncer(upstream, downstream, resources.List(), cluster)
+// SetResources changes the resource types synced by a running Syncer,
+// without restarting the informers of resource types that are still synced.
+func (s *Syncer) SetResources(resources sets.String) {
+	s.specSyncer.setSyncedResourceTypes(resources.List())
+	s.statusSyncer.setSyncedResourceTypes(resources.List())
+	s.Resources = resources
+}
+
+func StartSyncer(upstream, downstream *rest.Config, resources sets.String, cluster string, numSyncerThreads int, opts Options) (*Syncer, error) {
+	specSyncer, err := NewSpecSyncer(upstream, downstream, resources.List(), cluster, opts)
 	if err != nil {
 		return nil, err
 	}
-	statusSyncer, err := NewStatusSyncer(downstream, upstream, resources.List(), cluster)
+	statusSyncer, err := NewStatusSyncer(downstream, upstream, resources.List(), cluster, opts)
 	if err != nil {
 		specSyncer.Stop()
 		return nil, err
@@ -64,69 +141,144 @@ func StartSyncer(upstream, downstream *rest.Config, resources sets.String, clust
 }
 
 type UpsertFunc func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, unstrob *unstructured.Unstructured) error
-type DeleteFunc func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) error
+type DeleteFunc func(c *Controller, ctx context.Context, gvr schema.GroupVersionResource, namespace string, meta metav1.Object) error
 type HandlersProvider func(c *Controller, gvr schema.GroupVersionResource) cache.ResourceEventHandlerFuncs
 
 type Controller struct {
-	queue workqueue.RateLimitingInterface
+	queue *resourceQueues
 
 	// Upstream
-	fromDSIF dynamicinformer.DynamicSharedInformerFactory
+	fromClient          dynamic.Interface
+	fromDiscovery       discovery.DiscoveryInterface
+	informersLock       sync.RWMutex
+	informers           map[schema.GroupVersionResource]*resourceInformer
+	handlers            HandlersProvider
+	downstreamHandlers  HandlersProvider
+	syncedResourceTypes []string
+	clusterID           string
+	direction           string
+
+	clusterScopedResources sets.String
 
 	// Downstream
 	toClient dynamic.Interface
 
 	stopCh chan struct{}
+	// lastProcessed is the time, in Unix nanoseconds, at which the last queue item was processed.
+	lastProcessed int64
 
 	upsertFn UpsertFunc
 	deleteFn DeleteFunc
 
-	namespace string
+	namespace       string
+	namespaceMapper NamespaceMapper
+	transformers    []resourceTransformers
+	driftPolicy     string
+	logicalCluster  string
+	adoptPolicy     string
+	dependencies    *dependencies
+	// restMapper resolves the kinds of the owners of upstream objects.
+	restMapper *restmapper.DeferredDiscoveryRESTMapper
+	// orphansCleanedUp is closed once the spec syncer cleaned up the orphaned downstream objects on startup.
+	orphansCleanedUp chan struct{}
+	// failures are the items which currently fail to sync.
+	failuresLock sync.Mutex
+	failures     map[string]string
+	// once makes the controller record the items to check again later as blocked, instead of requeueing them.
+	once bool
+	// blocked are the items waiting to be checked again later, in once mode.
+	blockedLock sync.Mutex
+	blocked     map[string]blockedItem
+	// sharedInformers provide the upstream informers of the spec syncer, when syncing several clusters.
+	sharedInformers *sharedInformers
+	// statusSubresources discovers the status subresources of the upstream resource types,
+	// on the "from" side for the spec syncer, and on the "to" side for the status syncer.
+	statusSubresources *statusSubresources
 }
 
 // New returns a new syncer Controller syncing spec from "from" to "to".
-func New(from, to *rest.Config, upsertFn UpsertFunc, deleteFn DeleteFunc, handlers HandlersProvider, syncedResourceTypes []string, clusterID string) (*Controller, error) {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+// The direction labels the metrics of the controller.
+func New(from, to *rest.Config, upsertFn UpsertFunc, deleteFn DeleteFunc, handlers HandlersProvider, syncedResourceTypes []string, clusterID, direction string, opts Options) (*Controller, error) {
+	RegisterMetrics()
+	queue := newResourceQueues(clusterID, direction, opts.ResourceWorkers)
 	stopCh := make(chan struct{})
 
-	c := Controller{
-		// TODO: should we have separate upstream and downstream sync workqueues?
-		queue: queue,
-
-		toClient: dynamic.NewForConfigOrDie(to),
+	namespaceMapper, err := NewNamespaceMapper(opts.NamespaceMapping)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTransformations(opts.Transformations); err != nil {
+		return nil, err
+	}
 
-		stopCh: stopCh,
+	fromDiscovery, err := discovery.NewDiscoveryClientForConfig(from)
+	if err != nil {
+		return nil, err
+	}
 
-		upsertFn:  upsertFn,
-		deleteFn:  deleteFn,
-		namespace: os.Getenv(SyncerNamespaceKey),
+	fromClient, toClient := dynamic.NewForConfigOrDie(from), dynamic.NewForConfigOrDie(to)
+	if opts.DryRun {
+		fromSide, toSide := "upstream", "downstream"
+		if direction == statusDirection {
+			fromSide, toSide = toSide, fromSide
+		}
+		fromClient = newDryRunClient(fromClient, fromSide, opts.DryRunOutput)
+		toClient = newDryRunClient(toClient, toSide, opts.DryRunOutput)
 	}
 
-	fromClient := dynamic.NewForConfigOrDie(from)
-	fromDSIF := dynamicinformer.NewFilteredDynamicSharedInformerFactory(fromClient, resyncPeriod, metav1.NamespaceAll, func(o *metav1.ListOptions) {
-		o.LabelSelector = fmt.Sprintf("kcp.dev/cluster=%s", clusterID)
-	})
+	c := Controller{
+		queue: queue,
+
+		fromClient:          fromClient,
+		fromDiscovery:       fromDiscovery,
+		informers:           map[schema.GroupVersionResource]*resourceInformer{},
+		handlers:            handlers,
+		syncedResourceTypes: syncedResourceTypes,
+		clusterID:           clusterID,
+		direction:           direction,
+
+		clusterScopedResources: sets.NewString(opts.ClusterScopedResources...),
+
+		toClient: toClient,
+
+		stopCh:        stopCh,
+		lastProcessed: time.Now().UnixNano(),
+
+		upsertFn:        upsertFn,
+		deleteFn:        deleteFn,
+		namespace:       os.Getenv(SyncerNamespaceKey),
+		namespaceMapper: namespaceMapper,
+		transformers:    newResourceTransformers(opts.Transformations),
+		driftPolicy:     opts.DriftPolicy,
+		logicalCluster:  opts.LogicalCluster,
+		adoptPolicy:     opts.AdoptPolicy,
+		failures:        map[string]string{},
+		once:            opts.Once,
+		blocked:         map[string]blockedItem{},
+		restMapper:      restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(fromDiscovery)),
+	}
+	if direction == specDirection {
+		c.sharedInformers = opts.sharedInformers
+	}
 
 	// Get all types the upstream API server knows about.
-	// TODO: watch this and learn about new types, or forget about old ones.
-	gvrstrs, err := getAllGVRs(from, syncedResourceTypes...)
+	gvrstrs, notFoundResourceTypes, err := discoverGVRs(fromDiscovery, c.clusterScopedResources, syncedResourceTypes...)
 	if err != nil {
 		return nil, err
 	}
+	if notFoundResourceTypes.Len() != 0 {
+		// Some of the API resources expected to be there are still not published by KCP.
+		// They will be picked up by the periodic discovery once the corresponding resources
+		// are added inside KCP as CRDs and published as API resources.
+		klog.Infof("The following resource types were requested to be synced, but were not found yet in the KCP logical cluster: %v", notFoundResourceTypes.List())
+	}
 	for _, gvrstr := range gvrstrs {
 		gvr, _ := schema.ParseResourceArg(gvrstr)
-
-		if _, err := fromDSIF.ForResource(*gvr).Lister().List(labels.Everything()); err != nil {
-			klog.Infof("Failed to list all %q: %v", gvrstr, err)
-			return nil, errors.NewRetryableError(err)
-		}
-
-		fromDSIF.ForResource(*gvr).Informer().AddEventHandler(handlers(&c, *gvr))
-		klog.Infof("Set up informer for %v", gvr)
+		c.addInformer(*gvr)
 	}
-	fromDSIF.WaitForCacheSync(stopCh)
-	fromDSIF.Start(stopCh)
-	c.fromDSIF = fromDSIF
+
+	// Watch discovery to learn about new types, and forget about old ones.
+	go wait.Until(c.refreshInformers, discoveryPollInterval, stopCh)
 
 	return &c, nil
 }
@@ -140,13 +292,12 @@ func contains(ss []string, s string) bool {
 	return false
 }
 
-func getAllGVRs(config *rest.Config, resourcesToSync ...string) ([]string, error) {
+// discoverGVRs returns the GVRs of the requested resource types that are currently
+// served by the API server, along with the requested resource types that were not found.
+// Cluster-scoped resource types are only returned if they are in the clusterScoped allowlist.
+func discoverGVRs(dc discovery.DiscoveryInterface, clusterScoped sets.String, resourcesToSync ...string) ([]string, sets.String, error) {
 	toSyncSet := sets.NewString(resourcesToSync...)
 	willBeSyncedSet := sets.NewString()
-	dc, err := discovery.NewDiscoveryClientForConfig(config)
-	if err != nil {
-		return nil, err
-	}
 	rs, err := dc.ServerPreferredResources()
 	if err != nil {
 		if strings.Contains(err.Error(), "unable to retrieve the complete list of server APIs") {
@@ -156,9 +307,9 @@ func getAllGVRs(config *rest.Config, resourcesToSync ...string) ([]string, error
 			// In fact this might be related to a bug in the changes made on the feature-logical-cluster
 			// Kubernetes branch to support legacy schema resources added as CRDs.
 			// If this is confirmed, this test will be removed when the CRD bug is fixed.
-			return nil, errors.NewRetryableError(err)
+			return nil, nil, errors.NewRetryableError(err)
 		} else {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 	var gvrstrs []string
@@ -192,8 +343,15 @@ func getAllGVRs(config *rest.Config, resourcesToSync ...string) ([]string, error
 				continue
 			}
 			if !ai.Namespaced {
-				// Ignore cluster-scoped things.
-				continue
+				if groupResource == namespacesGVR.GroupResource() {
+					// Namespaces are synced on their own.
+					continue
+				}
+				if !clusterScoped.Has(groupResource.String()) && !clusterScoped.Has(ai.Name) {
+					// Ignore cluster-scoped things, unless explicitly allowed.
+					klog.Infof("resource %s %s is cluster-scoped, and not in the allowed cluster-scoped resources", vr, ai.Name)
+					continue
+				}
 			}
 			if !contains(ai.Verbs, "watch") {
 				klog.Infof("resource %s %s is not watchable: %v", vr, ai.Name, ai.Verbs)
@@ -204,14 +362,7 @@ func getAllGVRs(config *rest.Config, resourcesToSync ...string) ([]string, error
 		}
 	}
 
-	notFoundResourceTypes := toSyncSet.Difference(willBeSyncedSet)
-	if notFoundResourceTypes.Len() != 0 {
-		// Some of the API resources expected to be there are still not published by KCP.
-		// We should just retry without a limit on the number of retries in such a case,
-		// until the corresponding resources are added inside KCP as CRDs and published as API resources.
-		return nil, errors.NewRetryableError(fmt.Errorf("The following resource types were requested to be synced, but were not found in the KCP logical cluster: %v", notFoundResourceTypes.List()))
-	}
-	return gvrstrs, nil
+	return gvrstrs, toSyncSet.Difference(willBeSyncedSet), nil
 }
 
 type holder struct {
@@ -223,22 +374,21 @@ func (c *Controller) AddToQueue(gvr schema.GroupVersionResource, obj interface{}
 	c.queue.AddRateLimited(holder{gvr: gvr, obj: obj})
 }
 
-// Start starts N worker processes processing work items.
+// Start starts N worker processes processing work items, for each synced resource type
+// which has no specific number of workers in the options.
 func (c *Controller) Start(numThreads int) {
-	for i := 0; i < numThreads; i++ {
-		go c.startWorker()
-	}
+	c.queue.start(numThreads, c.startWorker)
 }
 
-// startWorker processes work items until stopCh is closed.
-func (c *Controller) startWorker() {
+// startWorker processes work items of the queue until stopCh is closed.
+func (c *Controller) startWorker(queue workqueue.RateLimitingInterface) {
 	for {
 		select {
 		case <-c.stopCh:
 			klog.Info("stopping syncer worker")
 			return
 		default:
-			c.processNextWorkItem()
+			c.processNextWorkItem(queue)
 		}
 	}
 }
@@ -247,24 +397,46 @@ func (c *Controller) startWorker() {
 func (c *Controller) Stop() {
 	c.queue.ShutDown()
 	close(c.stopCh)
+	c.stopInformers()
+	c.deleteMetrics()
 }
 
 // Done returns a channel that's closed when the syncer is stopped.
 func (c *Controller) Done() <-chan struct{} { return c.stopCh }
 
-func (c *Controller) processNextWorkItem() bool {
+func (c *Controller) processNextWorkItem(queue workqueue.RateLimitingInterface) bool {
 	// Wait until there is a new item in the working queue
-	i, quit := c.queue.Get()
+	i, quit := queue.Get()
 	if quit {
 		return false
 	}
-	h := i.(holder)
+	c.queue.observeDepth(i, queue)
+	c.queue.begin()
+	defer c.queue.end()
 
 	// No matter what, tell the queue we're done with this key, to unblock
 	// other workers.
-	defer c.queue.Done(i)
-
-	err := c.process(h.gvr, h.obj)
+	defer queue.Done(i)
+
+	// The item is reported as failed again if it still fails after its retries.
+	c.clearFailure(i)
+	c.clearBlocked(i)
+	start := time.Now()
+	var err error
+	switch key := i.(type) {
+	case driftKey:
+		err = c.processDrift(key)
+	case dependencyKey:
+		err = c.processDependency(context.TODO(), key)
+	default:
+		h := i.(holder)
+		err = c.process(h.gvr, h.obj)
+	}
+	c.observeSync(i, start, err)
+	if err == nil {
+		c.clearSyncFailure(i)
+	}
+	atomic.StoreInt64(&c.lastProcessed, time.Now().UnixNano())
 	c.handleErr(err, i)
 	return true
 }
@@ -286,6 +458,9 @@ func (c *Controller) handleErr(err error, i interface{}) {
 
 	// Give up and report error elsewhere.
 	c.queue.Forget(i)
+	c.observeDrop(i)
+	c.recordFailure(i, err)
+	c.reportSyncFailure(i, err)
 	utilruntime.HandleError(err)
 	klog.Errorf("Dropping key %q after failed retries: %v", i, err)
 }
@@ -315,7 +490,13 @@ func (c *Controller) process(gvr schema.GroupVersionResource, obj interface{}) e
 
 	ctx := context.TODO()
 
-	obj, exists, err := c.fromDSIF.ForResource(gvr).Informer().GetIndexer().Get(obj)
+	informer, found := c.getInformer(gvr)
+	if !found {
+		klog.Infof("Dropping object with gvr=%q that is not synced anymore: %s/%s", gvr, namespace, name)
+		return nil
+	}
+
+	obj, exists, err := informer.GetIndexer().Get(obj)
 	if err != nil {
 		klog.Error(err)
 		return err
@@ -323,7 +504,10 @@ func (c *Controller) process(gvr schema.GroupVersionResource, obj interface{}) e
 
 	if !exists {
 		klog.Infof("Object with gvr=%q was deleted : %s/%s", gvr, namespace, name)
-		return c.deleteFn(c, ctx, gvr, namespace, name)
+		if c.deleteFn == nil {
+			return nil
+		}
+		return c.deleteFn(c, ctx, gvr, namespace, meta)
 	}
 
 	unstrob, isUnstructured := obj.(*unstructured.Unstructured)
@@ -349,6 +533,15 @@ func (c *Controller) getClient(gvr schema.GroupVersionResource, namespace string
 	return nri
 }
 
+// getFromClient gets a dynamic client for the GVR on the "from" side, scoped to namespace if the namespace is not "".
+func (c *Controller) getFromClient(gvr schema.GroupVersionResource, namespace string) dynamic.ResourceInterface {
+	nri := c.fromClient.Resource(gvr)
+	if namespace != "" {
+		return nri.Namespace(namespace)
+	}
+	return nri
+}
+
 func (c *Controller) inSyncerNamespace(objectNamespace string) bool {
 	// If there is no value for the syncer namespace then always process the object
 	// This will also handle the cluster scoped objects case for
@@ -0,0 +1,195 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// syncStatusAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+// which records on the upstream object that its sync to the cluster failed persistently.
+const syncStatusAnnotationPrefix = "kcp.dev/sync-status."
+
+// syncedSpecAnnotationPrefix is the prefix of the annotation, suffixed with the cluster ID,
+// which records on the upstream object the version of its spec last applied to the cluster.
+const syncedSpecAnnotationPrefix = "kcp.dev/synced-spec."
+
+var eventsGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// SyncStatus is recorded, as JSON, in the kcp.dev/sync-status.<cluster> annotation of an upstream object
+// whose sync to the cluster was given up after failed retries. The annotation is removed once the sync succeeds.
+type SyncStatus struct {
+	// Reason is the reason of the last error, e.g. Forbidden or Invalid.
+	Reason string `json:"reason"`
+	// Message is the message of the last error.
+	Message string `json:"message"`
+	// LastAttemptTime is the time of the last failed attempt.
+	LastAttemptTime metav1.Time `json:"lastAttemptTime"`
+}
+
+// SyncedSpec is recorded, as JSON, in the kcp.dev/synced-spec.<cluster> annotation of an upstream object
+// each time a new generation of it is applied to the cluster.
+type SyncedSpec struct {
+	// Generation is the generation of the upstream object which was applied.
+	Generation int64 `json:"generation"`
+	// ResourceVersion is the resource version of the upstream object which was applied.
+	ResourceVersion string `json:"resourceVersion"`
+	// SyncTime is the time it was applied.
+	SyncTime metav1.Time `json:"syncTime"`
+}
+
+// GetSyncedSpec returns the version of the spec of the upstream object last applied to the cluster,
+// or nil if it was never applied.
+func GetSyncedSpec(obj metav1.Object, clusterID string) (*SyncedSpec, error) {
+	value, found := obj.GetAnnotations()[syncedSpecAnnotationPrefix+clusterID]
+	if !found {
+		return nil, nil
+	}
+	synced := &SyncedSpec{}
+	if err := json.Unmarshal([]byte(value), synced); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", syncedSpecAnnotationPrefix+clusterID, err)
+	}
+	return synced, nil
+}
+
+// SpecSynced returns whether the current generation of the upstream object was applied to the cluster.
+// Whether the cluster acted on it is reflected by the observed generation in the status of the upstream object.
+func SpecSynced(obj metav1.Object, clusterID string) bool {
+	synced, err := GetSyncedSpec(obj, clusterID)
+	return err == nil && synced != nil && synced.Generation >= obj.GetGeneration()
+}
+
+// recordSyncedSpec records on the upstream object that its generation was applied to the cluster.
+// It is only rewritten when the generation changes, since writing the annotation changes the resource version
+// of the object, and a failed write leaves the previous generation recorded, so it is retried on the next sync.
+// Objects synced as dependencies are not assigned to the cluster, so nothing is recorded on them.
+func (c *Controller) recordSyncedSpec(ctx context.Context, gvr schema.GroupVersionResource, upstreamObj *unstructured.Unstructured) error {
+	if syncedAsDependency(upstreamObj) {
+		return nil
+	}
+	if synced, err := GetSyncedSpec(upstreamObj, c.clusterID); err == nil && synced != nil && synced.Generation == upstreamObj.GetGeneration() {
+		return nil
+	}
+	data, err := json.Marshal(SyncedSpec{
+		Generation:      upstreamObj.GetGeneration(),
+		ResourceVersion: upstreamObj.GetResourceVersion(),
+		SyncTime:        metav1.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.setFromAnnotation(ctx, gvr, upstreamObj, syncedSpecAnnotationPrefix+c.clusterID, string(data))
+}
+
+// cachedUpstream returns the current upstream object of a queued holder from the informer cache,
+// or nil if the controller is not the spec syncer or the object doesn't exist anymore.
+func (c *Controller) cachedUpstream(i interface{}) *unstructured.Unstructured {
+	h, isHolder := i.(holder)
+	if !isHolder || c.direction != specDirection {
+		return nil
+	}
+	informer, found := c.getInformer(h.gvr)
+	if !found {
+		return nil
+	}
+	obj, exists, err := informer.GetIndexer().Get(h.obj)
+	if err != nil || !exists {
+		return nil
+	}
+	unstrob, _ := obj.(*unstructured.Unstructured)
+	return unstrob
+}
+
+// reportSyncFailure records the error on the upstream object of a holder given up after failed retries,
+// and emits a warning Event about it in the logical cluster.
+func (c *Controller) reportSyncFailure(i interface{}, syncErr error) {
+	upstream := c.cachedUpstream(i)
+	if upstream == nil {
+		return
+	}
+	gvr := i.(holder).gvr
+	ctx := context.TODO()
+
+	reason := string(k8serrors.ReasonForError(syncErr))
+	if reason == "" {
+		reason = "SyncFailed"
+	}
+	status, err := json.Marshal(SyncStatus{
+		Reason:          reason,
+		Message:         syncErr.Error(),
+		LastAttemptTime: metav1.Now(),
+	})
+	if err != nil {
+		klog.Errorf("Encoding sync status of upstream resource %s/%s: %v", upstream.GetNamespace(), upstream.GetName(), err)
+		return
+	}
+	if err := c.setFromAnnotation(ctx, gvr, upstream, syncStatusAnnotationPrefix+c.clusterID, string(status)); err != nil {
+		klog.Errorf("Reporting sync failure on upstream resource %s/%s: %v", upstream.GetNamespace(), upstream.GetName(), err)
+	}
+	if err := c.emitFromEvent(ctx, upstream, corev1.EventTypeWarning, "SyncFailed",
+		fmt.Sprintf("Failed to sync to cluster %s: %v", c.clusterID, syncErr)); err != nil {
+		klog.Errorf("Emitting sync failure event for upstream resource %s/%s: %v", upstream.GetNamespace(), upstream.GetName(), err)
+	}
+}
+
+// clearSyncFailure removes the sync failure recorded on the upstream object of a holder, after it synced successfully.
+func (c *Controller) clearSyncFailure(i interface{}) {
+	upstream := c.cachedUpstream(i)
+	if upstream == nil {
+		return
+	}
+	if err := c.setFromAnnotation(context.TODO(), i.(holder).gvr, upstream, syncStatusAnnotationPrefix+c.clusterID, ""); err != nil {
+		klog.Errorf("Clearing sync failure on upstream resource %s/%s: %v", upstream.GetNamespace(), upstream.GetName(), err)
+	}
+}
+
+// emitFromEvent creates an Event about the object on the "from" side, in the namespace and logical cluster of the object.
+func (c *Controller) emitFromEvent(ctx context.Context, involved *unstructured.Unstructured, eventType, reason, message string) error {
+	namespace := involved.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%v.%x", involved.GetName(), time.Now().UnixNano()),
+			Namespace:   namespace,
+			ClusterName: involved.GetClusterName(),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      involved.GetAPIVersion(),
+			Kind:            involved.GetKind(),
+			Namespace:       involved.GetNamespace(),
+			Name:            involved.GetName(),
+			UID:             involved.GetUID(),
+			ResourceVersion: involved.GetResourceVersion(),
+		},
+		Type:                eventType,
+		Reason:              reason,
+		Message:             message,
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		Source:              corev1.EventSource{Component: c.fieldManager()},
+		ReportingController: "kcp.dev/syncer",
+		ReportingInstance:   c.clusterID,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(event)
+	if err != nil {
+		return err
+	}
+	unstrob := &unstructured.Unstructured{Object: content}
+	unstrob.SetAPIVersion("v1")
+	unstrob.SetKind("Event")
+	_, err = c.getFromClient(eventsGVR, namespace).Create(ctx, unstrob, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestRecordSyncedSpec(t *testing.T) {
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            "config",
+			"namespace":       "ns",
+			"generation":      int64(2),
+			"resourceVersion": "10",
+			"annotations": map[string]interface{}{
+				syncedSpecAnnotationPrefix + "east": `{"generation":2,"resourceVersion":"9","syncTime":null}`,
+			},
+		},
+	}}
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), obj)
+	c := &Controller{fromClient: client, clusterID: "east"}
+
+	// The resource version changed by the previous write doesn't make it write again.
+	if err := c.recordSyncedSpec(context.Background(), configmaps, obj); err != nil {
+		t.Fatalf("recordSyncedSpec() = %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("recordSyncedSpec() of a recorded generation = %v, want no write", actions)
+	}
+
+	obj.SetGeneration(3)
+	if err := c.recordSyncedSpec(context.Background(), configmaps, obj); err != nil {
+		t.Fatalf("recordSyncedSpec() = %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 1 || actions[0].GetVerb() != "patch" {
+		t.Errorf("recordSyncedSpec() of a new generation = %v, want a patch", actions)
+	}
+}
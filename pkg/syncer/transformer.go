@@ -0,0 +1,278 @@
+package syncer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// Transformer transforms the objects synced between upstream and downstream.
+type Transformer interface {
+	// ToDownstream transforms the copy of an upstream object which is synced downstream.
+	ToDownstream(obj *unstructured.Unstructured) error
+	// FromDownstream reverses the transformation on the copy of a downstream object
+	// whose status is synced upstream.
+	FromDownstream(obj *unstructured.Unstructured) error
+}
+
+// TransformationSpec describes the transformations applied to the objects of some resource types.
+// The transformations are applied in the order of the fields.
+type TransformationSpec struct {
+	// Resources are the resource types, e.g. deployments.apps, the transformations apply to.
+	// They apply to all the synced resource types if empty.
+	Resources []string `json:"resources,omitempty"`
+
+	// StripAnnotations are the annotations removed from downstream objects.
+	// A trailing * matches any annotation with the given prefix.
+	StripAnnotations []string `json:"stripAnnotations,omitempty"`
+	// InjectLabels are the labels added to downstream objects.
+	InjectLabels map[string]string `json:"injectLabels,omitempty"`
+	// RewriteImageRegistries maps upstream image registries to the downstream registries they are replaced with.
+	RewriteImageRegistries map[string]string `json:"rewriteImageRegistries,omitempty"`
+	// DropFields are the dot-separated paths of the fields removed from downstream objects, e.g. spec.template.spec.nodeSelector.
+	DropFields []string `json:"dropFields,omitempty"`
+
+	// StatusFields are the dot-separated paths of the status fields synced upstream, e.g. status.replicas.
+	// All the status fields are synced upstream if empty.
+	StatusFields []string `json:"statusFields,omitempty"`
+	// DropStatusFields are the dot-separated paths of the status fields which are not synced upstream,
+	// e.g. status.podIP.
+	DropStatusFields []string `json:"dropStatusFields,omitempty"`
+}
+
+// LoadTransformations reads a YAML list of TransformationSpecs from a file.
+func LoadTransformations(path string) ([]TransformationSpec, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []TransformationSpec
+	if err := yaml.UnmarshalStrict(bytes, &specs); err != nil {
+		return nil, fmt.Errorf("invalid transformations in %s: %w", path, err)
+	}
+	if err := validateTransformations(specs); err != nil {
+		return nil, fmt.Errorf("invalid transformations in %s: %w", path, err)
+	}
+	return specs, nil
+}
+
+// validateTransformations returns an error if the status fields of a spec are not under status.
+func validateTransformations(specs []TransformationSpec) error {
+	for _, spec := range specs {
+		for _, field := range append(append([]string{}, spec.StatusFields...), spec.DropStatusFields...) {
+			if !strings.HasPrefix(field, "status.") {
+				return fmt.Errorf("status field %q is not under status", field)
+			}
+		}
+	}
+	return nil
+}
+
+// Transformers returns the chain of Transformers described by the spec.
+func (s TransformationSpec) Transformers() []Transformer {
+	var transformers []Transformer
+	if len(s.StripAnnotations) > 0 {
+		transformers = append(transformers, stripAnnotations(s.StripAnnotations))
+	}
+	if len(s.InjectLabels) > 0 {
+		transformers = append(transformers, injectLabels(s.InjectLabels))
+	}
+	if len(s.RewriteImageRegistries) > 0 {
+		transformers = append(transformers, rewriteImageRegistries(s.RewriteImageRegistries))
+	}
+	if len(s.DropFields) > 0 {
+		transformers = append(transformers, dropFields(s.DropFields))
+	}
+	if len(s.StatusFields) > 0 {
+		transformers = append(transformers, selectStatusFields(s.StatusFields))
+	}
+	if len(s.DropStatusFields) > 0 {
+		transformers = append(transformers, dropStatusFields(s.DropStatusFields))
+	}
+	return transformers
+}
+
+type resourceTransformers struct {
+	resources    sets.String
+	transformers []Transformer
+}
+
+func newResourceTransformers(specs []TransformationSpec) []resourceTransformers {
+	var rts []resourceTransformers
+	for _, spec := range specs {
+		rts = append(rts, resourceTransformers{
+			resources:    sets.NewString(spec.Resources...),
+			transformers: spec.Transformers(),
+		})
+	}
+	return rts
+}
+
+// transformersFor returns the chain of Transformers which apply to the GVR.
+func (c *Controller) transformersFor(gvr schema.GroupVersionResource) []Transformer {
+	var transformers []Transformer
+	for _, rt := range c.transformers {
+		if rt.resources.Len() == 0 || rt.resources.Has(gvr.GroupResource().String()) || rt.resources.Has(gvr.Resource) {
+			transformers = append(transformers, rt.transformers...)
+		}
+	}
+	return transformers
+}
+
+// transformToDownstream applies the chain of Transformers of the GVR to an object synced downstream.
+func (c *Controller) transformToDownstream(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	for _, t := range c.transformersFor(gvr) {
+		if err := t.ToDownstream(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transformFromDownstream reverses the chain of Transformers of the GVR on an object whose status is synced upstream.
+func (c *Controller) transformFromDownstream(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	transformers := c.transformersFor(gvr)
+	for i := len(transformers) - 1; i >= 0; i-- {
+		if err := transformers[i].FromDownstream(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type stripAnnotations []string
+
+func (s stripAnnotations) ToDownstream(obj *unstructured.Unstructured) error {
+	annotations := obj.GetAnnotations()
+	for key := range annotations {
+		for _, pattern := range s {
+			if key == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*"))) {
+				delete(annotations, key)
+			}
+		}
+	}
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+func (s stripAnnotations) FromDownstream(obj *unstructured.Unstructured) error { return nil }
+
+type injectLabels map[string]string
+
+func (l injectLabels) ToDownstream(obj *unstructured.Unstructured) error {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range l {
+		labels[k] = v
+	}
+	obj.SetLabels(labels)
+	return nil
+}
+
+func (l injectLabels) FromDownstream(obj *unstructured.Unstructured) error { return nil }
+
+// rewriteImageRegistries rewrites the registry of every "image" field in the spec of downstream
+// objects, and rewrites it back in the status of the objects synced upstream.
+type rewriteImageRegistries map[string]string
+
+func (r rewriteImageRegistries) ToDownstream(obj *unstructured.Unstructured) error {
+	if spec, found := obj.Object["spec"]; found {
+		rewriteImages(spec, map[string]string(r))
+	}
+	return nil
+}
+
+func (r rewriteImageRegistries) FromDownstream(obj *unstructured.Unstructured) error {
+	reverse := map[string]string{}
+	for from, to := range r {
+		reverse[to] = from
+	}
+	if status, found := obj.Object["status"]; found {
+		rewriteImages(status, reverse)
+	}
+	return nil
+}
+
+func rewriteImages(field interface{}, registries map[string]string) {
+	switch typed := field.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			if image, isString := value.(string); isString && key == "image" {
+				typed[key] = rewriteImage(image, registries)
+				continue
+			}
+			rewriteImages(value, registries)
+		}
+	case []interface{}:
+		for _, item := range typed {
+			rewriteImages(item, registries)
+		}
+	}
+}
+
+func rewriteImage(image string, registries map[string]string) string {
+	for from, to := range registries {
+		if strings.HasPrefix(image, from+"/") {
+			return to + strings.TrimPrefix(image, from)
+		}
+	}
+	return image
+}
+
+type dropFields []string
+
+func (d dropFields) ToDownstream(obj *unstructured.Unstructured) error {
+	for _, field := range d {
+		unstructured.RemoveNestedField(obj.Object, strings.Split(field, ".")...)
+	}
+	return nil
+}
+
+func (d dropFields) FromDownstream(obj *unstructured.Unstructured) error { return nil }
+
+// selectStatusFields only keeps the given status fields on the objects whose status is synced upstream.
+// The status is removed if none of them is found, and then not synced upstream.
+type selectStatusFields []string
+
+func (s selectStatusFields) ToDownstream(obj *unstructured.Unstructured) error { return nil }
+
+func (s selectStatusFields) FromDownstream(obj *unstructured.Unstructured) error {
+	selected := map[string]interface{}{}
+	for _, field := range s {
+		path := strings.Split(field, ".")
+		value, found, err := unstructured.NestedFieldNoCopy(obj.Object, path...)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if err := unstructured.SetNestedField(selected, value, path...); err != nil {
+			return err
+		}
+	}
+	if status, found := selected["status"]; found {
+		obj.Object["status"] = status
+	} else {
+		delete(obj.Object, "status")
+	}
+	return nil
+}
+
+type dropStatusFields []string
+
+func (d dropStatusFields) ToDownstream(obj *unstructured.Unstructured) error { return nil }
+
+func (d dropStatusFields) FromDownstream(obj *unstructured.Unstructured) error {
+	for _, field := range d {
+		unstructured.RemoveNestedField(obj.Object, strings.Split(field, ".")...)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package syncer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestTransformations(t *testing.T) {
+	c := &Controller{
+		transformers: newResourceTransformers([]TransformationSpec{{
+			Resources:              []string{"deployments.apps"},
+			StripAnnotations:       []string{"example.com/*"},
+			InjectLabels:           map[string]string{"env": "prod"},
+			RewriteImageRegistries: map[string]string{"quay.io": "mirror.local"},
+			DropFields:             []string{"spec.template.spec.nodeSelector"},
+		}}),
+	}
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "foo",
+			"annotations": map[string]interface{}{
+				"example.com/a": "a",
+				"other":         "b",
+			},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"nodeSelector": map[string]interface{}{"disk": "ssd"},
+					"containers": []interface{}{
+						map[string]interface{}{"name": "c", "image": "quay.io/foo/bar:v1"},
+					},
+				},
+			},
+		},
+	}}
+	if err := c.transformToDownstream(deployments, obj); err != nil {
+		t.Fatalf("transformToDownstream() = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "foo",
+			"annotations": map[string]interface{}{"other": "b"},
+			"labels":      map[string]interface{}{"env": "prod"},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "c", "image": "mirror.local/foo/bar:v1"},
+					},
+				},
+			},
+		},
+	}
+	if !equality.Semantic.DeepEqual(obj.Object, want) {
+		t.Errorf("transformToDownstream() = %v, want %v", obj.Object, want)
+	}
+
+	if transformers := c.transformersFor(schema.GroupVersionResource{Version: "v1", Resource: "services"}); len(transformers) != 0 {
+		t.Errorf("transformersFor(services) = %v, want none", transformers)
+	}
+}
+
+func TestStatusTransformations(t *testing.T) {
+	c := &Controller{
+		transformers: newResourceTransformers([]TransformationSpec{{
+			Resources:        []string{"pods"},
+			StatusFields:     []string{"status.phase", "status.conditions", "status.podIP"},
+			DropStatusFields: []string{"status.podIP"},
+		}}),
+	}
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"spec":     map[string]interface{}{"nodeName": "node1"},
+		"status": map[string]interface{}{
+			"phase":      "Running",
+			"podIP":      "10.0.0.1",
+			"hostIP":     "192.168.0.1",
+			"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+		},
+	}}
+	if err := c.transformFromDownstream(pods, obj); err != nil {
+		t.Fatalf("transformFromDownstream() = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"spec":     map[string]interface{}{"nodeName": "node1"},
+		"status": map[string]interface{}{
+			"phase":      "Running",
+			"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+		},
+	}
+	if !equality.Semantic.DeepEqual(obj.Object, want) {
+		t.Errorf("transformFromDownstream() = %v, want %v", obj.Object, want)
+	}
+}
+
+func TestSelectStatusFieldsNotFound(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"status":   map[string]interface{}{"hostIP": "192.168.0.1"},
+	}}
+	if err := selectStatusFields([]string{"status.phase"}).FromDownstream(obj); err != nil {
+		t.Fatalf("FromDownstream() = %v", err)
+	}
+	if _, found := obj.Object["status"]; found {
+		t.Errorf("FromDownstream() kept status %v, want none", obj.Object["status"])
+	}
+
+	if err := validateTransformations([]TransformationSpec{{StatusFields: []string{"phase"}}}); err == nil {
+		t.Errorf("validateTransformations() accepted a status field outside of status")
+	}
+}